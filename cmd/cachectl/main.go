@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+const usage = `Usage: cachectl [-file path] <command> [args]
+
+Commands:
+  list [prefix]   List cached keys, optionally only those starting with prefix
+  show <key>      Print the cached result of a key as JSON
+  delete <key>    Remove a single entry
+  purge [prefix]  Remove every entry, or those starting with prefix
+  compact         Rewrite the cache file without outdated records
+  stats           Print entry count and file size
+
+The server must be stopped while the cache file is modified.
+`
+
+// cachectl inspects and maintains the persistent processing cache
+func main() {
+	config.LoadEnv()
+
+	file := flag.String("file", config.GetCacheFile(), "Path of the cache file")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// No limits, so inspecting the cache never evicts anything
+	cache, err := service.OpenDiskCache(*file, 0, 0, config.GetCacheTTL(), false)
+	if err != nil {
+		log.Fatal("Failed to open cache: ", err)
+	}
+	defer cache.Close()
+
+	arg := ""
+	if len(args) > 1 {
+		arg = args[1]
+	}
+
+	switch args[0] {
+	case "list":
+		for _, entry := range cache.Entries() {
+			if strings.HasPrefix(entry.Key, arg) {
+				fmt.Printf("%s\texpires %s\t%d variants\n", entry.Key, entry.Expires.Format("2006-01-02 15:04:05"), len(entry.Result.Variants))
+			}
+		}
+	case "show":
+		for _, entry := range cache.Entries() {
+			if entry.Key == arg {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				encoder.Encode(entry)
+				return
+			}
+		}
+		log.Fatalf("No entry for key %q", arg)
+	case "delete":
+		found, err := cache.Delete(arg)
+		if err != nil {
+			log.Fatal("Failed to delete entry: ", err)
+		}
+		if !found {
+			log.Fatalf("No entry for key %q", arg)
+		}
+		fmt.Println("Deleted", arg)
+	case "purge":
+		removed, err := cache.Purge(arg)
+		if err != nil {
+			log.Fatal("Failed to purge cache: ", err)
+		}
+		fmt.Printf("Removed %d entries\n", removed)
+	case "compact":
+		if err := cache.Compact(); err != nil {
+			log.Fatal("Failed to compact cache: ", err)
+		}
+		fmt.Println("Compacted", *file)
+	case "stats":
+		stats := cache.Stats()
+		fmt.Printf("Entries: %d\nFile size: %d bytes\n", stats.Entries, stats.Bytes)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
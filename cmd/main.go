@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/abhinandpn/CompressImage/internal/config"
 	handler "github.com/abhinandpn/CompressImage/internal/handler" // ✅ Import the handler package
+	"github.com/abhinandpn/CompressImage/internal/service"
 	"github.com/abhinandpn/CompressImage/server"
 )
 
@@ -16,19 +18,33 @@ func main() {
 	server.StartImaginaryServer()
 	// Load environment variables
 	config.LoadEnv()
+	// Refuse to start with a key template that could overwrite objects
+	if err := service.ValidateKeyTemplate(config.GetKeyTemplate()); err != nil {
+		log.Fatal("Invalid OBJECT_KEY_TEMPLATE: ", err)
+	}
+	// Load the S3 upload profiles requests can choose from
+	if err := service.LoadS3Profiles(config.GetS3ProfilesFile()); err != nil {
+		log.Fatal("Failed to load S3 profiles: ", err)
+	}
+	// Set up the processing cache from the environment
+	if err := service.ConfigureCache(); err != nil {
+		log.Fatal("Failed to set up cache: ", err)
+	}
+	// Start the workers processing asynchronous uploads and their webhooks
+	service.ConfigureWebhooks(context.Background())
+	if err := service.ConfigureJobs(context.Background()); err != nil {
+		log.Fatal("Failed to open job queue: ", err)
+	}
 	// Create storage directory if it doesn't exist
 	err := os.MkdirAll("storage", os.ModePerm)
 	if err != nil {
 		log.Fatal("Failed to create storage directory:", err)
 	}
 
-	// Register HTTP handlers
-	http.HandleFunc("/upload", handler.UploadImageHandler) // ✅ Now handler is recognized
-	http.HandleFunc("/s3upload", handler.S3ImageHandler)   // ✅ Now handler is recognized
-
 	port := "3000"
 	fmt.Println("Server running on port:", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	// Versioned routes, with the old unversioned paths as deprecated aliases
+	if err := http.ListenAndServe(":"+port, handler.NewRouter()); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// s3process runs the variant pipeline over images already stored in S3
+func main() {
+	config.LoadEnv()
+
+	src := flag.String("src", "", "S3 prefix to read originals from")
+	dst := flag.String("dst", config.GetS3DestinationPrefix(), "S3 prefix to write variants under")
+	tenant := flag.String("tenant", service.DefaultTenant, "Tenant used in variant keys")
+	profile := flag.String("profile", service.DefaultS3Profile, "Upload profile applied to variant objects")
+	jsonReport := flag.Bool("json", false, "Print the final report as JSON")
+	flag.Parse()
+
+	if err := service.ValidateKeyTemplate(config.GetKeyTemplate()); err != nil {
+		log.Fatal("Invalid OBJECT_KEY_TEMPLATE: ", err)
+	}
+	if err := service.LoadS3Profiles(config.GetS3ProfilesFile()); err != nil {
+		log.Fatal("Failed to load S3 profiles: ", err)
+	}
+
+	// Variants are encoded into the storage folder before upload
+	if err := os.MkdirAll("storage", os.ModePerm); err != nil {
+		log.Fatal("Failed to create storage directory:", err)
+	}
+
+	// Ctrl-C aborts the uploads in progress instead of leaving incomplete parts behind
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := service.ProcessS3Prefix(ctx, service.UploadOptions{Tenant: service.SanitizeTenant(*tenant), Profile: *profile}, *src, *dst, func(done, total int, result service.BulkObjectResult) {
+		line := fmt.Sprintf("[%d/%d] %s: %s", done, total, result.Key, result.Status)
+		if result.Error != "" {
+			line += " (" + result.Error + ")"
+		}
+		fmt.Println(line)
+	})
+	if err != nil {
+		log.Fatal("Failed to process S3 prefix:", err)
+	}
+
+	if *jsonReport {
+		json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		fmt.Printf("Total: %d, processed: %d, skipped: %d, failed: %d\n", report.Total, report.Processed, report.Skipped, report.Failed)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
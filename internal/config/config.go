@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -56,3 +58,288 @@ func GetAWSSecretKey() string {
 func GetAWSBucketName() string {
 	return os.Getenv("AWS_BUCKET_NAME")
 }
+
+// GetS3DestinationPrefix returns the key prefix processed variants are written under
+func GetS3DestinationPrefix() string {
+	prefix := os.Getenv("AWS_S3_DEST_PREFIX")
+	if prefix == "" {
+		prefix = "imaginary/" // Default prefix used by S3 uploads
+	}
+	return prefix
+}
+
+// GetKeyTemplate returns the template used to name stored variants
+func GetKeyTemplate() string {
+	template := os.Getenv("OBJECT_KEY_TEMPLATE")
+	if template == "" {
+		template = "{basename}_{sha256:8}_{variant}.{ext}" // Unique per image content and variant
+	}
+	return template
+}
+
+// GetS3ProfilesFile returns the path of the JSON file defining S3 upload profiles
+func GetS3ProfilesFile() string {
+	return os.Getenv("S3_PROFILES_FILE")
+}
+
+// GetS3UploadMaxAttempts returns how often an upload failing its integrity check is tried
+func GetS3UploadMaxAttempts() int {
+	attempts, err := strconv.Atoi(os.Getenv("S3_UPLOAD_MAX_ATTEMPTS"))
+	if err != nil || attempts < 1 {
+		attempts = 3
+	}
+	return attempts
+}
+
+// GetS3MultipartThreshold returns the file size from which uploads are split into parts
+func GetS3MultipartThreshold() int64 {
+	return getEnvInt64("S3_MULTIPART_THRESHOLD", 16*1024*1024)
+}
+
+// GetS3MultipartPartSize returns the size of each part of a multipart upload
+func GetS3MultipartPartSize() int64 {
+	return getEnvInt64("S3_MULTIPART_PART_SIZE", 8*1024*1024)
+}
+
+// GetS3MultipartConcurrency returns how many parts are uploaded at the same time
+func GetS3MultipartConcurrency() int {
+	return int(getEnvInt64("S3_MULTIPART_CONCURRENCY", 4))
+}
+
+// getEnvInt64 reads a positive number from the environment, falling back to def
+func getEnvInt64(name string, def int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value < 1 {
+		return def
+	}
+	return value
+}
+
+// getEnvCount reads a number from the environment that may be 0, falling back to def
+func getEnvCount(name string, def int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value < 0 {
+		return def
+	}
+	return value
+}
+
+// GetCacheMaxEntries returns how many processed images the cache keeps
+func GetCacheMaxEntries() int {
+	return int(getEnvInt64("CACHE_MAX_ENTRIES", 1000))
+}
+
+// GetCacheMaxBytes returns how much data the cache may hold
+func GetCacheMaxBytes() int64 {
+	return getEnvInt64("CACHE_MAX_BYTES", 64*1024*1024)
+}
+
+// GetCacheTTL returns how long a cached result stays valid
+func GetCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return ttl
+}
+
+// GetCacheValidate reports whether cached results are checked against the store before use
+func GetCacheValidate() bool {
+	validate, err := strconv.ParseBool(os.Getenv("CACHE_VALIDATE"))
+	if err != nil {
+		return true
+	}
+	return validate
+}
+
+// GetCacheBackend returns where processed results are cached ("memory", "disk" or "redis")
+func GetCacheBackend() string {
+	backend := os.Getenv("CACHE_BACKEND")
+	if backend == "" {
+		backend = "memory"
+	}
+	return backend
+}
+
+// GetCacheFile returns the file used by the disk cache
+func GetCacheFile() string {
+	path := os.Getenv("CACHE_FILE")
+	if path == "" {
+		path = "storage/cache.db"
+	}
+	return path
+}
+
+// GetRedisAddr returns the address of the Redis server used by the redis cache
+func GetRedisAddr() string {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	return addr
+}
+
+// GetRedisPassword returns the Redis password
+func GetRedisPassword() string {
+	return os.Getenv("REDIS_PASSWORD")
+}
+
+// GetRedisDB returns the Redis database number
+func GetRedisDB() int {
+	db, err := strconv.Atoi(os.Getenv("REDIS_DB"))
+	if err != nil || db < 0 {
+		return 0
+	}
+	return db
+}
+
+// GetRedisKeyPrefix returns the prefix put in front of every cache key in Redis
+func GetRedisKeyPrefix() string {
+	prefix := os.Getenv("REDIS_KEY_PREFIX")
+	if prefix == "" {
+		prefix = "compressimage:"
+	}
+	return prefix
+}
+
+// GetMaxImagePixels returns the largest width * height accepted for uploads
+func GetMaxImagePixels() int64 {
+	return getEnvInt64("MAX_IMAGE_PIXELS", 50*1000*1000)
+}
+
+// getEnvDuration reads a positive duration such as "90s" from the environment
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// GetJobWorkers returns how many asynchronous jobs are processed at once
+func GetJobWorkers() int {
+	return int(getEnvInt64("JOB_WORKERS", 4))
+}
+
+// GetJobQueueSize returns how many jobs may wait for a worker before new ones are refused
+func GetJobQueueSize() int {
+	return int(getEnvInt64("JOB_QUEUE_SIZE", 100))
+}
+
+// GetJobRetention returns how long finished jobs can still be queried
+func GetJobRetention() time.Duration {
+	return getEnvDuration("JOB_RETENTION", time.Hour)
+}
+
+// GetWebhookSecret returns the key webhook payloads are signed with. Callbacks
+// are refused while it is empty.
+func GetWebhookSecret() string {
+	return os.Getenv("WEBHOOK_SECRET")
+}
+
+// GetWebhookMaxAttempts returns how often a webhook delivery is tried
+func GetWebhookMaxAttempts() int {
+	return int(getEnvInt64("WEBHOOK_MAX_ATTEMPTS", 5))
+}
+
+// GetWebhookBackoff returns the wait before the first webhook retry; it doubles with every attempt
+func GetWebhookBackoff() time.Duration {
+	return getEnvDuration("WEBHOOK_BACKOFF", 2*time.Second)
+}
+
+// GetWebhookAllowPrivate reports whether callback URLs may point at private,
+// loopback or link-local addresses, for receivers on the internal network
+func GetWebhookAllowPrivate() bool {
+	allow, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE"))
+	return allow
+}
+
+// GetJobDir returns the directory asynchronous jobs and their inputs are kept in
+func GetJobDir() string {
+	dir := os.Getenv("JOB_DIR")
+	if dir == "" {
+		dir = "storage/jobs"
+	}
+	return dir
+}
+
+// GetJobMaxAttempts returns how often a job failing with a transient error is tried
+func GetJobMaxAttempts() int {
+	return int(getEnvInt64("JOB_MAX_ATTEMPTS", 5))
+}
+
+// GetJobBackoff returns the wait before a job is first retried; it doubles with every attempt
+func GetJobBackoff() time.Duration {
+	return getEnvDuration("JOB_BACKOFF", 5*time.Second)
+}
+
+// GetUploadMaxFileBytes returns the largest file accepted in an upload
+func GetUploadMaxFileBytes() int64 {
+	return getEnvInt64("UPLOAD_MAX_FILE_BYTES", 10*1024*1024)
+}
+
+// GetUploadMaxRequestBytes returns the largest upload request body accepted
+func GetUploadMaxRequestBytes() int64 {
+	return getEnvInt64("UPLOAD_MAX_REQUEST_BYTES", 100*1024*1024)
+}
+
+// GetUploadMaxMemoryBytes returns how much of an upload is kept in memory
+// before further files are spooled to disk
+func GetUploadMaxMemoryBytes() int64 {
+	return getEnvInt64("UPLOAD_MAX_MEMORY_BYTES", 10*1024*1024)
+}
+
+// GetUploadMaxFiles returns how many files a single upload may contain
+func GetUploadMaxFiles() int {
+	return int(getEnvInt64("UPLOAD_MAX_FILES", 50))
+}
+
+// GetUploadTempDir returns the directory uploads are spooled to; empty means
+// the system temporary directory
+func GetUploadTempDir() string {
+	return os.Getenv("UPLOAD_TEMP_DIR")
+}
+
+// GetArchiveMaxBytes returns the largest ZIP archive accepted in an upload
+func GetArchiveMaxBytes() int64 {
+	return getEnvInt64("ARCHIVE_MAX_BYTES", 200*1024*1024)
+}
+
+// GetArchiveMaxEntries returns how many entries an uploaded archive may contain
+func GetArchiveMaxEntries() int {
+	return int(getEnvInt64("ARCHIVE_MAX_ENTRIES", 1000))
+}
+
+// GetArchiveMaxUncompressedBytes returns how large the entries of an archive
+// may be once extracted, all together
+func GetArchiveMaxUncompressedBytes() int64 {
+	return getEnvInt64("ARCHIVE_MAX_UNCOMPRESSED_BYTES", 1024*1024*1024)
+}
+
+// GetImageDir returns the directory recording where the variants of every
+// processed image are stored
+func GetImageDir() string {
+	dir := os.Getenv("IMAGE_DIR")
+	if dir == "" {
+		dir = "storage/images"
+	}
+	return dir
+}
+
+// GetRemoteFetchMaxBytes returns the largest image fetched from a remote URL
+func GetRemoteFetchMaxBytes() int64 {
+	return getEnvInt64("REMOTE_FETCH_MAX_BYTES", 10*1024*1024)
+}
+
+// GetRemoteFetchMaxRedirects returns how many redirects are followed when
+// fetching a remote URL; 0 refuses every redirect
+func GetRemoteFetchMaxRedirects() int {
+	return int(getEnvCount("REMOTE_FETCH_MAX_REDIRECTS", 3))
+}
+
+// GetRemoteFetchAllowPrivate reports whether remote URLs may point at private,
+// loopback or link-local addresses. It is meant for local development only.
+func GetRemoteFetchAllowPrivate() bool {
+	allow, _ := strconv.ParseBool(os.Getenv("REMOTE_FETCH_ALLOW_PRIVATE"))
+	return allow
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// ArchiveUploadHandler handles a ZIP archive of images. Every image entry is
+// processed like an uploaded file and stored under its path in the archive, so
+// the folder structure carries over to the variant keys. Other entries are
+// reported as skipped. The archive is refused as a whole when it has too many
+// entries, is too large once extracted or contains unsafe paths.
+func ArchiveUploadHandler(w http.ResponseWriter, r *http.Request) {
+	opts := uploadOptionsFromRequest(r)
+	if _, ok := service.GetS3Profile(opts.Profile); !ok {
+		writeError(w, r, service.ErrCodeUnknownProfile, "Unknown upload profile", nil)
+		return
+	}
+
+	limits := uploadLimitsFromConfig()
+	limits.MaxFileBytes = config.GetArchiveMaxBytes()
+	limits.MaxRequestBytes = limits.MaxFileBytes + maxFieldBytes*16 // Room for the other form fields
+	limits.MaxFiles = 1
+	limits.ContentTypes = []string{"application/zip"}
+	limits.TypeError = "File is not a ZIP archive"
+	form, err := readUploadForm(w, r, "archive", limits)
+	if err != nil {
+		formError(w, r, err)
+		return
+	}
+	defer form.RemoveAll()
+
+	if len(form.Files) == 0 {
+		writeError(w, r, service.ErrCodeNoFiles, "No archive uploaded", nil)
+		return
+	}
+	store, err := parseStore(form.Value("store"))
+	if err != nil {
+		writeServiceError(w, r, err, "Invalid store")
+		return
+	}
+
+	file, err := form.Files[0].Open()
+	if err != nil {
+		writeServiceError(w, r, err, "Failed to read archive")
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, form.Files[0].Size)
+	if err != nil {
+		writeError(w, r, service.ErrCodeUnsupportedFormat, "Archive is not a valid ZIP file", err)
+		return
+	}
+	entries, err := archiveEntries(archive)
+	if err != nil {
+		writeServiceError(w, r, err, "Invalid archive")
+		return
+	}
+	if len(entries) == 0 {
+		writeError(w, r, service.ErrCodeNoFiles, "Archive contains no files", nil)
+		return
+	}
+
+	stream := newEventStream(w, r)
+	images := make([]ImageResponse, 0, len(entries))
+	processed := 0
+	for i, entry := range entries {
+		if stream != nil {
+			stream.send(eventFileStarted, FileEvent{Index: i, Filename: entry.Path})
+		}
+
+		image := processArchiveEntry(r, stream, i, store, entry)
+		if image.Status != imageSkipped {
+			processed++
+		}
+		images = append(images, image)
+		if stream != nil {
+			stream.send(eventFileDone, FileEvent{Index: i, Filename: entry.Path, Image: &image})
+		}
+	}
+
+	if processed == 0 {
+		if stream != nil {
+			stream.fail(service.ErrCodeNoFiles, "Archive contains no images", nil)
+		} else {
+			writeError(w, r, service.ErrCodeNoFiles, "Archive contains no images", nil)
+		}
+		return
+	}
+	finishUpload(w, r, stream, images)
+}
+
+// archiveEntry is a file of an uploaded archive with its checked path
+type archiveEntry struct {
+	Path string
+	file *zip.File
+}
+
+// archiveEntries lists the files of an archive, leaving out folders and
+// metadata added by archivers. It fails when the archive exceeds the entry or
+// size limits or when an entry path could escape the folder it is stored in.
+func archiveEntries(archive *zip.Reader) ([]archiveEntry, error) {
+	maxEntries := config.GetArchiveMaxEntries()
+	if len(archive.File) > maxEntries {
+		return nil, service.NewError(service.ErrCodeTooLarge, fmt.Sprintf("Archive may contain at most %d entries", maxEntries), nil)
+	}
+
+	maxTotal := config.GetArchiveMaxUncompressedBytes()
+	var total uint64
+	var entries []archiveEntry
+	for _, f := range archive.File {
+		name, err := archiveEntryPath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._") {
+			continue
+		}
+		// Sizes are declared by the archive; the ZIP reader refuses entries that exceed them
+		total += f.UncompressedSize64
+		if total > uint64(maxTotal) {
+			return nil, service.NewError(service.ErrCodeTooLarge, fmt.Sprintf("Archive exceeds %d bytes once extracted", maxTotal), nil)
+		}
+		entries = append(entries, archiveEntry{Path: name, file: f})
+	}
+	return entries, nil
+}
+
+// archiveEntryPath checks an entry name and returns it as a clean relative
+// path. Absolute paths, drive letters and ".." segments are refused.
+func archiveEntryPath(name string) (string, error) {
+	unsafe := service.NewError(service.ErrCodeInvalidRequest, fmt.Sprintf("Archive entry %q has an unsafe path", name), nil)
+
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", unsafe
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", unsafe
+		}
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", unsafe
+	}
+	return cleaned, nil
+}
+
+// processArchiveEntry stores the variants of one archive entry. Entries are
+// read like uploaded files, with the same size limit and type sniffing; those
+// that are not images are skipped, and failures are reported in the response.
+func processArchiveEntry(r *http.Request, stream *eventStream, index int, store string, entry archiveEntry) ImageResponse {
+	rc, err := entry.file.Open()
+	if err != nil {
+		err = service.NewError(service.ErrCodeInvalidRequest, "Failed to read archive entry", err)
+		return failedImageResponse(r, entry.Path, err, "Failed to read file")
+	}
+	defer rc.Close()
+
+	limits := uploadLimitsFromConfig()
+	memory := limits.MaxMemoryBytes
+	form := newUploadForm(r)
+	defer form.RemoveAll()
+	file, err := form.readFile(rc, entry.Path, limits, &memory)
+	if err != nil {
+		err = service.NewError(service.ErrCodeInvalidRequest, "Failed to read archive entry", err)
+		return failedImageResponse(r, entry.Path, err, "Failed to read file")
+	}
+	if service.ErrorCode(file.err) == service.ErrCodeUnsupportedFormat {
+		return ImageResponse{
+			Filename: entry.Path,
+			Status:   imageSkipped,
+			Paths:    map[string]string{},
+			Variants: []service.VariantResult{},
+		}
+	}
+
+	data, imgConfig, err := file.Load()
+	if err != nil {
+		return failedImageResponse(r, entry.Path, err, "Failed to decode image")
+	}
+	return processImage(r, stream, index, store, entry.Path, data, imgConfig)
+}
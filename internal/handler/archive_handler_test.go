@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+func TestArchiveEntryPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // Empty when the path must be refused
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"trips/2024/photo.jpg", "trips/2024/photo.jpg"},
+		{"trips//2024/./photo.jpg", "trips/2024/photo.jpg"},
+		{`trips\2024\photo.jpg`, "trips/2024/photo.jpg"},
+		{"trips/", "trips"},
+		{"..photo.jpg", "..photo.jpg"},
+		{"photo..jpg", "photo..jpg"},
+		{"", ""},
+		{".", ""},
+		{"./", ""},
+		{"/etc/passwd", ""},
+		{`\windows\system.ini`, ""},
+		{"C:/windows/system.ini", ""},
+		{`C:\windows\system.ini`, ""},
+		{"c:photo.jpg", ""},
+		{"..", ""},
+		{"../photo.jpg", ""},
+		{"trips/../../photo.jpg", ""},
+		{"trips/../photo.jpg", ""},
+		{`trips\..\..\photo.jpg`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := archiveEntryPath(tt.name)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("archiveEntryPath(%q) = %q, want an error", tt.name, got)
+				}
+				if code := service.ErrorCode(err); code != service.ErrCodeInvalidRequest {
+					t.Errorf("got error code %s, want %s", code, service.ErrCodeInvalidRequest)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("archiveEntryPath(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+		})
+	}
+}
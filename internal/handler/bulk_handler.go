@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// S3BulkHandler processes every image already stored under an S3 prefix. Runs
+// over large prefixes take long, so with ?stream=sse or ?stream=ndjson (or a
+// matching Accept header) a progress event is sent as each object is done and
+// the report follows as the final event. The run stops when the client goes
+// away; started again, it skips the objects already processed.
+func S3BulkHandler(w http.ResponseWriter, r *http.Request) {
+	var req S3BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, service.ErrCodeInvalidRequest, "Invalid request body", err)
+		return
+	}
+	opts := service.UploadOptions{Tenant: service.SanitizeTenant(req.Tenant), Profile: req.Profile}
+	if _, ok := service.GetS3Profile(opts.Profile); !ok {
+		writeError(w, r, service.ErrCodeUnknownProfile, "Unknown upload profile", nil)
+		return
+	}
+	if req.DestPrefix == "" {
+		req.DestPrefix = config.GetS3DestinationPrefix()
+	}
+
+	stream := newEventStream(w, r)
+	report, err := service.ProcessS3Prefix(r.Context(), opts, req.SourcePrefix, req.DestPrefix, func(done, total int, result service.BulkObjectResult) {
+		log.Printf("[%d/%d] %s: %s", done, total, result.Key, result.Status)
+		if stream != nil {
+			stream.send(eventProgress, BulkProgressEvent{Done: done, Total: total, Object: result})
+		}
+	})
+	if err != nil {
+		if stream != nil {
+			body := errorBody(err, "Failed to process S3 prefix")
+			stream.fail(body.Code, body.Message, err)
+			return
+		}
+		writeServiceError(w, r, err, "Failed to process S3 prefix")
+		return
+	}
+
+	if stream != nil {
+		stream.send(eventDone, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
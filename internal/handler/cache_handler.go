@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// CacheStatsHandler reports the hit, miss and eviction counters of the processing cache
+func CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(service.Cache.Stats())
+}
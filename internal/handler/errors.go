@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// errorStatus maps error codes to HTTP status codes
+var errorStatus = map[string]int{
+	service.ErrCodeInvalidRequest:     http.StatusBadRequest,
+	service.ErrCodeNoFiles:            http.StatusBadRequest,
+	service.ErrCodeUnsupportedFormat:  http.StatusUnsupportedMediaType,
+	service.ErrCodeTooLarge:           http.StatusRequestEntityTooLarge,
+	service.ErrCodePixelLimit:         http.StatusUnprocessableEntity,
+	service.ErrCodeUnknownProfile:     http.StatusBadRequest,
+	service.ErrCodeProcessingFailed:   http.StatusInternalServerError,
+	service.ErrCodeStorageUnavailable: http.StatusServiceUnavailable,
+	service.ErrCodeNotFound:           http.StatusNotFound,
+	service.ErrCodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	service.ErrCodeQueueFull:          http.StatusServiceUnavailable,
+	service.ErrCodeFetchFailed:        http.StatusBadGateway,
+	service.ErrCodeInternal:           http.StatusInternalServerError,
+}
+
+// writeError replies with the JSON error envelope. The cause is only logged,
+// so internal details never reach the client.
+func writeError(w http.ResponseWriter, r *http.Request, code, message string, cause error) {
+	if code == "" {
+		code = service.ErrCodeInternal
+	}
+	status, ok := errorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	if cause != nil {
+		log.Printf("%s %s: %s (%s): %v", r.Method, r.URL.Path, message, code, cause)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorBody{Code: code, Message: message}})
+}
+
+// writeServiceError replies with the code and message carried by err
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	var serviceErr *service.Error
+	if errors.As(err, &serviceErr) {
+		writeError(w, r, serviceErr.Code, serviceErr.Message, serviceErr.Err)
+		return
+	}
+	writeError(w, r, service.ErrCodeInternal, fallback, err)
+}
+
+// errorBody describes err for clients. Errors without a code only expose the
+// fallback message.
+func errorBody(err error, fallback string) ErrorBody {
+	var serviceErr *service.Error
+	if errors.As(err, &serviceErr) {
+		return ErrorBody{Code: serviceErr.Code, Message: serviceErr.Message}
+	}
+	return ErrorBody{Code: service.ErrCodeInternal, Message: fallback}
+}
+
+// formError classifies a failure to read an upload form
+func formError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, service.ErrCodeTooLarge, "Request body exceeds the upload limit", err)
+		return
+	}
+	var serviceErr *service.Error
+	if errors.As(err, &serviceErr) {
+		writeServiceError(w, r, err, "Failed to parse form")
+		return
+	}
+	writeError(w, r, service.ErrCodeInvalidRequest, "Failed to parse form", err)
+}
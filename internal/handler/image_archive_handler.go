@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// ImageArchiveHandler streams a ZIP of the uploaded file and every stored
+// variant of an image, read from the local storage or the S3 bucket holding
+// them. Entries are copied straight from the store to the client, so the
+// archive is never held in memory. Images of other tenants are reported as
+// not found.
+func ImageArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	record, err := service.GetImageRecord(r.PathValue("id"))
+	if err == nil && record.Tenant != service.SanitizeTenant(r.Header.Get("X-Tenant-ID")) {
+		err = service.NewError(service.ErrCodeNotFound, "Image not found", nil)
+	}
+	if err != nil {
+		writeServiceError(w, r, err, "Failed to find image")
+		return
+	}
+
+	// The uploaded file comes first; images stored before uploads were kept have none
+	entries := record.Variants
+	if record.Source != nil {
+		entries = append([]service.StoredVariant{*record.Source}, entries...)
+	}
+
+	// Every entry is opened first, so a missing one is still reported with a proper status
+	bodies := make([]io.ReadCloser, 0, len(entries))
+	defer func() {
+		for _, body := range bodies {
+			body.Close()
+		}
+	}()
+	for _, variant := range entries {
+		body, err := record.OpenVariant(r.Context(), variant)
+		if err != nil {
+			writeServiceError(w, r, err, "Failed to read image")
+			return
+		}
+		bodies = append(bodies, body)
+	}
+
+	name := strings.ReplaceAll(strings.TrimSuffix(path.Base(record.Filename), path.Ext(record.Filename)), `"`, "")
+	if name == "" || name == "." || name == "/" {
+		name = record.ID
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	archive := zip.NewWriter(w)
+	for i, variant := range entries {
+		// Variants are already compressed, so they are stored as they are
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:   variant.Name + path.Ext(variant.Location),
+			Method: zip.Store,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, bodies[i])
+		}
+		if err != nil {
+			// The response has started, so the client is left with an incomplete archive
+			log.Printf("%s %s: failed to send variant %s of image %s: %v", r.Method, r.URL.Path, variant.Name, record.ID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("%s %s: failed to finish archive of image %s: %v", r.Method, r.URL.Path, record.ID, err)
+	}
+}
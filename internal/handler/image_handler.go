@@ -1,89 +1,220 @@
 package handler
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"image"
-	_ "image/jpeg" // Import for JPEG decoding
-	_ "image/png"  // Import for PNG decoding
-	"io"
-	"io/ioutil"
+	"log"
 	"net/http"
 
 	"github.com/abhinandpn/CompressImage/internal/service"
 )
 
-// UploadImageHandler handles multiple image uploads
+// UploadImageHandler handles multiple image uploads, sent as a multipart form,
+// as JSON with base64 content or as a single raw image body. A file that cannot be
+// processed is reported with its error code while the other files are still
+// stored; with all_or_nothing=true every file is checked first and nothing is
+// stored unless all of them are valid. With ?stream=sse or ?stream=ndjson (or
+// a matching Accept header) progress is streamed as an event per file and per
+// variant instead of a single reply at the end.
 func UploadImageHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB limit per file
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	form, err := readImageUpload(w, r, uploadLimitsFromConfig())
+	if err != nil {
+		formError(w, r, err)
 		return
 	}
+	defer form.RemoveAll()
 
-	files := r.MultipartForm.File["image"]
+	files := form.Files
 	if len(files) == 0 {
-		http.Error(w, "No files uploaded", http.StatusBadRequest)
+		writeError(w, r, service.ErrCodeNoFiles, "No files uploaded", nil)
 		return
 	}
+	allOrNothing := form.Value("all_or_nothing") == "true"
 
-	var imagesData []map[string]interface{}
-
-	for _, fileHeader := range files {
-		file, err := fileHeader.Open()
-		if err != nil {
-			http.Error(w, "Failed to open file", http.StatusInternalServerError)
-			return
+	// Reject the whole batch before anything is stored
+	if allOrNothing {
+		for _, file := range files {
+			if _, _, err := file.Load(); err != nil {
+				body := errorBody(err, "Failed to read file")
+				writeError(w, r, body.Code, fmt.Sprintf("%s: %s", file.Filename, body.Message), err)
+				return
+			}
 		}
-		defer file.Close()
+	}
 
-		if fileHeader.Size > 10*1024*1024 {
-			http.Error(w, "File size exceeds 10MB", http.StatusBadRequest)
-			return
+	stream := newEventStream(w, r)
+	var images []ImageResponse
+
+	for i, file := range files {
+		if stream != nil {
+			stream.send(eventFileStarted, FileEvent{Index: i, Filename: file.Filename})
 		}
 
-		// Read file into memory
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		image := processUploadedFile(r, stream, i, file)
+		if image.Error != nil && allOrNothing {
+			// Files were checked up front, so only processing can still fail
+			message := fmt.Sprintf("%s: %s", file.Filename, image.Error.Message)
+			if stream != nil {
+				stream.fail(image.Error.Code, message, nil)
+			} else {
+				writeError(w, r, image.Error.Code, message, nil)
+			}
 			return
 		}
 
-		// Decode image to get dimensions
-		imgConfig, _, err := image.DecodeConfig(bytes.NewReader(fileBytes))
-		if err != nil {
-			http.Error(w, "Failed to decode image", http.StatusInternalServerError)
-			return
+		images = append(images, image)
+		if stream != nil {
+			stream.send(eventFileDone, FileEvent{Index: i, Filename: file.Filename, Image: &image})
 		}
-		originalWidth := imgConfig.Width
-		originalHeight := imgConfig.Height
+	}
 
-		// Process and compress image with aspect ratio preservation
-		imagePaths, err := service.ProcessAndCompressImage(fileHeader.Filename, fileBytes, fileHeader.Size, originalWidth, originalHeight)
-		if err != nil {
-			http.Error(w, "Failed to process image", http.StatusInternalServerError)
-			return
+	finishUpload(w, r, stream, images)
+}
+
+// finishUpload sends the outcome of an upload, as the final event when streaming
+func finishUpload(w http.ResponseWriter, r *http.Request, stream *eventStream, images []ImageResponse) {
+	if stream == nil {
+		writeUploadResponse(w, r, images)
+		return
+	}
+	status, message, failure := summarizeUpload(images)
+	if status == 0 {
+		stream.fail(failure.Code, failure.Message, nil)
+		return
+	}
+	stream.send(eventDone, UploadResponse{Message: message, Images: images})
+}
+
+// processUploadedFile stores the variants of one uploaded file. Failures are
+// reported in the returned response rather than ending the request.
+func processUploadedFile(r *http.Request, stream *eventStream, index int, file *uploadedFile) ImageResponse {
+	fileBytes, imgConfig, err := file.Load()
+	if err != nil {
+		return failedImageResponse(r, file.Filename, err, "Failed to read file")
+	}
+	return processImage(r, stream, index, service.CacheBackendLocal, file.Filename, fileBytes, imgConfig)
+}
+
+// processImage stores the variants of an image locally or in S3, reporting
+// each variant to the stream if there is one
+func processImage(r *http.Request, stream *eventStream, index int, store, filename string, data []byte, imgConfig image.Config) ImageResponse {
+	opts := uploadOptionsFromRequest(r)
+	if stream != nil {
+		opts.OnVariant = func(variant service.VariantResult) {
+			stream.send(eventVariant, VariantEvent{Index: index, Filename: filename, Variant: variant})
 		}
+	}
 
-		// Calculate aspect ratio without max=10 constraint
-		aspectRatio := calculateAspectRatio(originalWidth, originalHeight)
+	// Process and compress image with aspect ratio preservation
+	var result service.ImageResult
+	var err error
+	if store == service.CacheBackendS3 {
+		result, err = service.S3ProcessAndCompressImage(r.Context(), opts, filename, data, int64(len(data)), imgConfig.Width, imgConfig.Height)
+	} else {
+		result, err = service.ProcessAndCompressImage(opts, filename, data, int64(len(data)), imgConfig.Width, imgConfig.Height)
+	}
+	if err != nil {
+		return failedImageResponse(r, filename, err, "Failed to process image")
+	}
+	return newImageResponse(filename, imgConfig.Width, imgConfig.Height, result)
+}
 
-		// Append image data
-		imagesData = append(imagesData, map[string]interface{}{
-			"filename":        fileHeader.Filename,
-			"aspect_ratio":    aspectRatio,
-			"original_width":  originalWidth,
-			"original_height": originalHeight,
-			"paths":           imagePaths,
-		})
+// parseStore validates the store a request asks for, defaulting to local storage
+func parseStore(value string) (string, error) {
+	switch value {
+	case "", service.CacheBackendLocal:
+		return service.CacheBackendLocal, nil
+	case service.CacheBackendS3:
+		return service.CacheBackendS3, nil
+	}
+	return "", service.NewError(service.ErrCodeInvalidRequest, "store must be local or s3", nil)
+}
+
+// failedImageResponse reports a file that could not be processed; the cause
+// is only logged
+func failedImageResponse(r *http.Request, filename string, err error, fallback string) ImageResponse {
+	body := errorBody(err, fallback)
+	log.Printf("%s %s: %s: %s (%s): %v", r.Method, r.URL.Path, filename, body.Message, body.Code, err)
+	return ImageResponse{
+		Filename: filename,
+		Status:   imageFailed,
+		Paths:    map[string]string{},
+		Variants: []service.VariantResult{},
+		Error:    &body,
+	}
+}
+
+// writeUploadResponse replies with the processed images. Partial failures are
+// reported with a multi-status response, and an error is returned when no
+// variant at all could be produced.
+func writeUploadResponse(w http.ResponseWriter, r *http.Request, images []ImageResponse) {
+	status, message, failure := summarizeUpload(images)
+	if status == 0 {
+		writeError(w, r, failure.Code, failure.Message, nil)
+		return
 	}
 
-	// Return response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Images uploaded successfully",
-		"images":  imagesData,
-	})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(UploadResponse{Message: message, Images: images})
+}
+
+// summarizeUpload picks the status and message describing the processed
+// images. A zero status means no variant could be produced, with failure
+// telling why.
+func summarizeUpload(images []ImageResponse) (status int, message string, failure ErrorBody) {
+	files, failedFiles, failedVariants, storedVariants := 0, 0, 0, 0
+	for _, img := range images {
+		if img.Status == imageSkipped {
+			continue
+		}
+		files++
+		if img.Error != nil {
+			failedFiles++
+			if failure.Code != service.ErrCodeStorageUnavailable {
+				failure = *img.Error
+			}
+			continue
+		}
+		for _, variant := range img.Variants {
+			if variant.Error == "" {
+				storedVariants++
+				continue
+			}
+			failedVariants++
+			// Storage problems win, since they usually affect every variant alike
+			if failure.Code != service.ErrCodeStorageUnavailable {
+				failure = ErrorBody{Code: variant.ErrorCode}
+			}
+		}
+	}
+
+	if storedVariants == 0 {
+		// The error of a single file describes the failure best
+		if files != 1 || failedFiles != 1 {
+			failure.Message = "No images could be processed"
+		}
+		return 0, failure.Message, failure
+	}
+	switch {
+	case failedFiles > 0 && failedVariants > 0:
+		return http.StatusMultiStatus, fmt.Sprintf("Images uploaded, but %d of %d files and %d variants could not be processed", failedFiles, files, failedVariants), failure
+	case failedFiles > 0:
+		return http.StatusMultiStatus, fmt.Sprintf("Images uploaded, but %d of %d files could not be processed", failedFiles, files), failure
+	case failedVariants > 0:
+		return http.StatusMultiStatus, fmt.Sprintf("Images uploaded, but %d variants could not be processed", failedVariants), failure
+	}
+	return http.StatusOK, "Images uploaded successfully", ErrorBody{}
+}
+
+// uploadOptionsFromRequest reads the tenant and upload profile from the
+// X-Tenant-ID and X-Upload-Profile headers
+func uploadOptionsFromRequest(r *http.Request) service.UploadOptions {
+	return service.UploadOptions{
+		Tenant:  service.SanitizeTenant(r.Header.Get("X-Tenant-ID")),
+		Profile: r.Header.Get("X-Upload-Profile"),
+	}
 }
 
 // Function to calculate the greatest common divisor (GCD)
@@ -109,59 +240,77 @@ func calculateAspectRatio(width, height int) string {
 
 // S3ImageHandler handles image upload, processing, and saving to S3
 func S3ImageHandler(w http.ResponseWriter, r *http.Request) {
-	// Limit the file size to prevent too large uploads
-	const maxFileSize = 10 * 1024 * 1024 // 10 MB
-	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize)
-
-	// Parse the form and get the file
-	err := r.ParseMultipartForm(maxFileSize)
-	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	upload, ok := readFormImage(w, r)
+	if !ok {
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("image")
+	// Call S3ProcessAndCompressImage to process and upload the image to S3
+	result, err := service.S3ProcessAndCompressImage(r.Context(), upload.Options, upload.Filename, upload.Data, upload.Size, upload.Width, upload.Height)
 	if err != nil {
-		http.Error(w, "Failed to retrieve file from form", http.StatusBadRequest)
+		writeServiceError(w, r, err, "Failed to process and upload image to S3")
 		return
 	}
-	defer file.Close()
 
-	// Read the file into a byte slice
-	fileBytes, err := ioutil.ReadAll(file)
-	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
-		return
-	}
+	// Return the S3 URLs of the uploaded images and the outcome of every variant
+	writeUploadResponse(w, r, []ImageResponse{newImageResponse(upload.Filename, upload.Width, upload.Height, result)})
+}
 
-	// Get the original image dimensions (width and height)
-	originalImage, _, err := image.Decode(bytes.NewReader(fileBytes))
-	if err != nil {
-		http.Error(w, "Failed to decode image", http.StatusInternalServerError)
-		return
+// formImage is a single image read from an upload form
+type formImage struct {
+	Options  service.UploadOptions
+	Filename string
+	Data     []byte
+	Size     int64
+	Width    int
+	Height   int
+	form     *uploadForm
+}
+
+// Value returns a form field or query parameter sent with the image
+func (u formImage) Value(name string) string {
+	return u.form.Value(name)
+}
+
+// readFormImage reads and checks the "image" file of a multipart form. It
+// replies with an error and returns false when the upload is unusable.
+func readFormImage(w http.ResponseWriter, r *http.Request) (formImage, bool) {
+	// The profile is known from the headers, so a bad one is refused before reading the body
+	opts := uploadOptionsFromRequest(r)
+	if _, ok := service.GetS3Profile(opts.Profile); !ok {
+		writeError(w, r, service.ErrCodeUnknownProfile, "Unknown upload profile", nil)
+		return formImage{}, false
 	}
-	originalWidth := originalImage.Bounds().Dx()
-	originalHeight := originalImage.Bounds().Dy()
 
-	// Call S3ProcessAndCompressImage to process and upload the image to S3
-	imagePaths, err := service.S3ProcessAndCompressImage(fileHeader.Filename, fileBytes, fileHeader.Size, originalWidth, originalHeight)
+	limits := uploadLimitsFromConfig()
+	limits.MaxFiles = 1
+	form, err := readUploadForm(w, r, "image", limits)
 	if err != nil {
-		http.Error(w, "Failed to process and upload image to S3", http.StatusInternalServerError)
-		return
+		formError(w, r, err)
+		return formImage{}, false
 	}
+	defer form.RemoveAll()
 
-	// Check if any images were uploaded successfully
-	if len(imagePaths) == 0 {
-		http.Error(w, "No images were uploaded", http.StatusInternalServerError)
-		return
+	if len(form.Files) == 0 {
+		writeError(w, r, service.ErrCodeNoFiles, "Failed to retrieve file from form", nil)
+		return formImage{}, false
 	}
+	file := form.Files[0]
 
-	// Return a success response with the S3 URLs of the uploaded images
-	response := map[string]interface{}{
-		"message":   "Image processed and uploaded successfully",
-		"imageUrls": imagePaths,
+	// Get the original image dimensions (width and height)
+	fileBytes, imgConfig, err := file.Load()
+	if err != nil {
+		writeServiceError(w, r, err, "Failed to decode image")
+		return formImage{}, false
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+
+	return formImage{
+		Options:  opts,
+		Filename: file.Filename,
+		Data:     fileBytes,
+		Size:     file.Size,
+		Width:    imgConfig.Width,
+		Height:   imgConfig.Height,
+		form:     form,
+	}, true
 }
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// CreateJobHandler accepts an image and processes it in the background. The
+// reply carries the job ID to poll, and the store query or form field picks
+// local storage (the default) or S3. A callback_url field asks for a signed
+// webhook once the job finishes.
+func CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := readFormImage(w, r)
+	if !ok {
+		return
+	}
+
+	store, err := parseStore(upload.Value("store"))
+	if err != nil {
+		writeServiceError(w, r, err, "Invalid store")
+		return
+	}
+
+	job, err := service.Jobs.Submit(service.JobInput{
+		Store:       store,
+		Options:     upload.Options,
+		Filename:    upload.Filename,
+		Data:        upload.Data,
+		Width:       upload.Width,
+		Height:      upload.Height,
+		CallbackURL: upload.Value("callback_url"),
+	})
+	if err != nil {
+		writeServiceError(w, r, err, "Failed to queue job")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newJobResponse(job))
+}
+
+// JobStatusHandler reports the progress and, once finished, the results of a job
+func JobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := service.Jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, service.ErrCodeNotFound, "Job not found", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newJobResponse(job))
+}
+
+// DeadLettersHandler lists the jobs that failed for good
+func DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	jobs := []JobResponse{}
+	for _, job := range service.Jobs.DeadLetters() {
+		jobs = append(jobs, newJobResponse(job))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// ReplayJobHandler queues a dead-lettered job again
+func ReplayJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := service.Jobs.Replay(r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, r, err, "Failed to replay job")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newJobResponse(job))
+}
+
+// DeleteJobHandler removes a finished job and its stored input
+func DeleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	if err := service.Jobs.Delete(r.PathValue("id")); err != nil {
+		writeServiceError(w, r, err, "Failed to delete job")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveriesHandler lists webhook deliveries and their attempts,
+// optionally filtered by the job_id and status query parameters
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(service.Webhooks.Deliveries(query.Get("job_id"), query.Get("status")))
+}
+
+// JobWebhooksHandler lists the webhook deliveries of a single job
+func JobWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := service.Jobs.Get(id); !ok {
+		writeError(w, r, service.ErrCodeNotFound, "Job not found", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(service.Webhooks.Deliveries(id, ""))
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// UploadResponse is returned by every upload endpoint
+type UploadResponse struct {
+	Message string          `json:"message"`
+	Images  []ImageResponse `json:"images"`
+}
+
+// Outcomes of a single image of an upload
+const (
+	imageSucceeded = "succeeded"
+	imagePartial   = "partial" // Some variants failed
+	imageFailed    = "failed"  // The file could not be processed at all
+	imageSkipped   = "skipped" // An archive entry that is not an image
+)
+
+// ImageResponse describes one uploaded image and its variants
+type ImageResponse struct {
+	ID             string                  `json:"id,omitempty"` // Downloads the stored variants from /v1/images/{id}/archive
+	Filename       string                  `json:"filename"`
+	Status         string                  `json:"status"`
+	Error          *ErrorBody              `json:"error,omitempty"` // Why a failed file could not be processed
+	AspectRatio    string                  `json:"aspect_ratio"`
+	OriginalWidth  int                     `json:"original_width"`
+	OriginalHeight int                     `json:"original_height"`
+	Paths          map[string]string       `json:"paths"` // Local paths or S3 URLs of the variants that succeeded
+	Variants       []service.VariantResult `json:"variants"`
+	CacheHit       bool                    `json:"cache_hit"`
+}
+
+// ErrorResponse is the JSON envelope of every error reply
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody holds the stable error code and a readable message
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSONUploadRequest carries images inline for clients that cannot send multipart forms
+type JSONUploadRequest struct {
+	Images []JSONImage `json:"images"`
+}
+
+// JSONImage is one image of a JSONUploadRequest
+type JSONImage struct {
+	Filename string `json:"filename"`
+	Content  string `json:"content"` // Base64 data or a base64 data URI such as data:image/png;base64,...
+}
+
+// RemoteImageRequest names the image RemoteImageHandler downloads
+type RemoteImageRequest struct {
+	URL   string `json:"url"`
+	Store string `json:"store,omitempty"` // local (default) or s3
+}
+
+// S3BulkRequest selects the objects S3BulkHandler processes
+type S3BulkRequest struct {
+	SourcePrefix string `json:"source_prefix"`
+	DestPrefix   string `json:"dest_prefix,omitempty"`
+	Tenant       string `json:"tenant,omitempty"`
+	Profile      string `json:"profile,omitempty"`
+}
+
+// BulkProgressEvent reports an object of a streamed S3 bulk run once it is done
+type BulkProgressEvent struct {
+	Done   int                      `json:"done"`
+	Total  int                      `json:"total"`
+	Object service.BulkObjectResult `json:"object"`
+}
+
+// JobResponse reports the state of an asynchronous upload. Variants lists
+// those finished so far, and Image is set once the job has finished.
+type JobResponse struct {
+	ID          string                  `json:"id"`
+	Status      string                  `json:"status"`
+	Store       string                  `json:"store"`
+	CallbackURL string                  `json:"callback_url,omitempty"`
+	Progress    JobProgress             `json:"progress"`
+	Attempts    int                     `json:"attempts"`
+	NextAttempt *time.Time              `json:"next_attempt,omitempty"` // Set while waiting to be retried
+	Variants    []service.VariantResult `json:"variants"`
+	Image       *ImageResponse          `json:"image,omitempty"`
+	Error       *ErrorBody              `json:"error,omitempty"`
+	CreatedAt   time.Time               `json:"created_at"`
+	StartedAt   *time.Time              `json:"started_at,omitempty"`
+	FinishedAt  *time.Time              `json:"finished_at,omitempty"`
+}
+
+// JobProgress counts the finished variants of a job
+type JobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// StreamEvent is one line of an NDJSON progress stream. Server-Sent Events
+// carry the same event name and data.
+type StreamEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// FileEvent reports that a file of a streamed upload started or finished
+type FileEvent struct {
+	Index    int            `json:"index"` // Position of the file in the form
+	Filename string         `json:"filename"`
+	Image    *ImageResponse `json:"image,omitempty"` // Set once the file is done
+}
+
+// VariantEvent reports a finished variant of a streamed upload
+type VariantEvent struct {
+	Index    int                   `json:"index"`
+	Filename string                `json:"filename"`
+	Variant  service.VariantResult `json:"variant"`
+}
+
+// newImageResponse builds the response entry of one processed image
+func newImageResponse(filename string, width, height int, result service.ImageResult) ImageResponse {
+	status := imageSucceeded
+	switch failed := len(result.Failed()); {
+	case failed == len(result.Variants):
+		status = imageFailed
+	case failed > 0:
+		status = imagePartial
+	}
+
+	return ImageResponse{
+		ID:             result.ID,
+		Filename:       filename,
+		Status:         status,
+		AspectRatio:    calculateAspectRatio(width, height),
+		OriginalWidth:  width,
+		OriginalHeight: height,
+		Paths:          result.Paths(),
+		Variants:       result.Variants,
+		CacheHit:       result.CacheHit,
+	}
+}
+
+// newJobResponse builds the response describing a job
+func newJobResponse(job service.Job) JobResponse {
+	resp := JobResponse{
+		ID:          job.ID,
+		Status:      job.Status,
+		Store:       job.Store,
+		CallbackURL: job.CallbackURL,
+		Progress:    JobProgress{Done: len(job.Variants), Total: job.Total},
+		Attempts:    job.Attempts,
+		Variants:    job.Variants,
+		CreatedAt:   job.CreatedAt,
+	}
+	if resp.Variants == nil {
+		resp.Variants = []service.VariantResult{}
+	}
+	if !job.StartedAt.IsZero() {
+		resp.StartedAt = &job.StartedAt
+	}
+	if !job.FinishedAt.IsZero() {
+		resp.FinishedAt = &job.FinishedAt
+	}
+	if !job.NextAttempt.IsZero() {
+		resp.NextAttempt = &job.NextAttempt
+	}
+	if job.Result != nil {
+		image := newImageResponse(job.Filename, job.Width, job.Height, *job.Result)
+		resp.Image = &image
+	}
+	if job.Err != nil {
+		body := errorBody(job.Err, "Failed to process image")
+		resp.Error = &body
+	}
+	return resp
+}
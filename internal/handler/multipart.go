@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// maxFieldBytes limits the plain form fields sent along with uploaded files
+const maxFieldBytes = 64 * 1024
+
+// uploadLimits bound what a single upload request may contain
+type uploadLimits struct {
+	MaxFileBytes    int64
+	MaxRequestBytes int64
+	MaxMemoryBytes  int64 // Files beyond this budget are spooled to disk
+	MaxFiles        int
+	TempDir         string
+	ContentTypes    []string // Accepted sniffed types; JPEG and PNG when empty
+	TypeError       string   // Why a file of another type is rejected
+}
+
+// uploadLimitsFromConfig reads the upload limits from the environment
+func uploadLimitsFromConfig() uploadLimits {
+	return uploadLimits{
+		MaxFileBytes:    config.GetUploadMaxFileBytes(),
+		MaxRequestBytes: config.GetUploadMaxRequestBytes(),
+		MaxMemoryBytes:  config.GetUploadMaxMemoryBytes(),
+		MaxFiles:        config.GetUploadMaxFiles(),
+		TempDir:         config.GetUploadTempDir(),
+	}
+}
+
+// uploadedFile is one file of an upload, held in memory or spooled to disk
+type uploadedFile struct {
+	Filename string
+	Size     int64
+	data     []byte // Content when kept in memory
+	path     string // Temporary file when spooled to disk
+	err      error  // Why the file was rejected while it was read
+}
+
+// uploadForm is a multipart upload read part by part
+type uploadForm struct {
+	Files  []*uploadedFile
+	values url.Values
+	query  url.Values
+	temps  []string
+}
+
+// newUploadForm starts an upload without files, whose values come from the query string
+func newUploadForm(r *http.Request) *uploadForm {
+	return &uploadForm{values: url.Values{}, query: r.URL.Query()}
+}
+
+// readUploadForm reads a multipart request, keeping the files sent under
+// field. Limits are enforced while the body is read: an oversized request is
+// refused before anything is read, and a file that is too large or is not an
+// image is rejected as soon as that is known, without being stored. Call
+// RemoveAll once the files are no longer needed.
+func readUploadForm(w http.ResponseWriter, r *http.Request, field string, limits uploadLimits) (*uploadForm, error) {
+	if r.ContentLength > limits.MaxRequestBytes {
+		return nil, &http.MaxBytesError{Limit: limits.MaxRequestBytes}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxRequestBytes)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := newUploadForm(r)
+	memory := limits.MaxMemoryBytes
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		switch {
+		case part.FileName() == "":
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldBytes+1))
+			if err == nil && len(value) > maxFieldBytes {
+				err = service.NewError(service.ErrCodeTooLarge, fmt.Sprintf("Form field %s is too long", part.FormName()), nil)
+			}
+			if err != nil {
+				form.RemoveAll()
+				return nil, err
+			}
+			form.values.Add(part.FormName(), string(value))
+		case part.FormName() != field:
+			// Files under other fields are not used
+			_, err = io.Copy(io.Discard, part)
+		case len(form.Files) >= limits.MaxFiles:
+			err = service.NewError(service.ErrCodeTooLarge, fmt.Sprintf("An upload may contain at most %d files", limits.MaxFiles), nil)
+		default:
+			var file *uploadedFile
+			file, err = form.readFile(part, part.FileName(), limits, &memory)
+			if err == nil {
+				form.Files = append(form.Files, file)
+			}
+		}
+		part.Close()
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+	}
+}
+
+// readFile reads a single file part. It is kept in memory while the budget
+// allows and is spooled to a temporary file otherwise. Rejected files are
+// drained, so the parts after them can still be read.
+func (f *uploadForm) readFile(part io.Reader, filename string, limits uploadLimits, memory *int64) (*uploadedFile, error) {
+	file := &uploadedFile{Filename: filename}
+
+	// The first bytes tell whether this is an image at all
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	if !limits.accepts(http.DetectContentType(head)) {
+		message := limits.TypeError
+		if message == "" {
+			message = "File is not a supported image (JPEG or PNG)"
+		}
+		file.err = service.NewError(service.ErrCodeUnsupportedFormat, message, nil)
+		_, err = io.Copy(io.Discard, part)
+		return file, err
+	}
+
+	content := io.LimitReader(io.MultiReader(bytes.NewReader(head), part), limits.MaxFileBytes+1)
+	tooLarge := func() (*uploadedFile, error) {
+		file.err = service.NewError(service.ErrCodeTooLarge, fmt.Sprintf("File size exceeds %d bytes", limits.MaxFileBytes), nil)
+		file.data = nil
+		_, err := io.Copy(io.Discard, part)
+		return file, err
+	}
+
+	var buf bytes.Buffer
+	size, err := io.CopyN(&buf, content, *memory+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if size > limits.MaxFileBytes {
+		return tooLarge()
+	}
+	if size <= *memory {
+		*memory -= size
+		file.data = buf.Bytes()
+		file.Size = size
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp(limits.TempDir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	f.temps = append(f.temps, tmp.Name())
+	size, err = io.Copy(tmp, io.MultiReader(&buf, content))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size > limits.MaxFileBytes {
+		os.Remove(tmp.Name())
+		return tooLarge()
+	}
+	file.path = tmp.Name()
+	file.Size = size
+	return file, nil
+}
+
+// accepts reports whether a file of the sniffed contentType may be uploaded
+func (l uploadLimits) accepts(contentType string) bool {
+	types := l.ContentTypes
+	if len(types) == 0 {
+		types = []string{"image/jpeg", "image/png"}
+	}
+	for _, t := range types {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns a form field, falling back to the query string like r.FormValue
+func (f *uploadForm) Value(name string) string {
+	if values, ok := f.values[name]; ok && len(values) > 0 {
+		return values[0]
+	}
+	return f.query.Get(name)
+}
+
+// RemoveAll deletes the temporary files of the upload
+func (f *uploadForm) RemoveAll() {
+	for _, path := range f.temps {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to remove temporary upload %s: %v", path, err)
+		}
+	}
+	f.temps = nil
+}
+
+// Load returns the content of the file and checks that it is a supported image
+func (u *uploadedFile) Load() ([]byte, image.Config, error) {
+	if u.err != nil {
+		return nil, image.Config{}, u.err
+	}
+
+	data := u.data
+	if u.path != "" {
+		var err error
+		if data, err = os.ReadFile(u.path); err != nil {
+			return nil, image.Config{}, service.NewError(service.ErrCodeInternal, "Failed to read file", err)
+		}
+	}
+
+	// Decode image to get dimensions
+	imgConfig, err := service.CheckImage(data)
+	if err != nil {
+		return nil, image.Config{}, err
+	}
+	return data, imgConfig, nil
+}
+
+// Open gives random access to the content of the file, as archive readers
+// need. Close the returned file once done.
+func (u *uploadedFile) Open() (readerAtCloser, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	if u.path == "" {
+		return nopCloserAt{bytes.NewReader(u.data)}, nil
+	}
+	f, err := os.Open(u.path)
+	if err != nil {
+		return nil, service.NewError(service.ErrCodeInternal, "Failed to read file", err)
+	}
+	return f, nil
+}
+
+// readerAtCloser is the content of an uploaded file opened for random access
+type readerAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// nopCloserAt is an in-memory file that needs no closing
+type nopCloserAt struct {
+	*bytes.Reader
+}
+
+func (nopCloserAt) Close() error { return nil }
@@ -0,0 +1,406 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiOperation documents one route of the API
+type apiOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Multipart   bool              // Accepts multipart uploads with "image" file fields
+	FileField   string            // File field of a multipart upload when not "image"
+	SingleFile  bool              // The multipart upload takes exactly one file
+	RawImage    bool              // Also accepts a single image as the raw request body
+	RequestBody interface{}       // Type of the JSON request body, if any
+	Query       map[string]string // Optional query parameters and their descriptions
+	Headers     map[string]string // Optional request headers and their descriptions
+	Deprecated  bool
+	Responses   map[int]interface{}
+}
+
+// binaryBody documents a response that is not JSON by its media type
+type binaryBody string
+
+// uploadResponses are shared by every upload endpoint
+var uploadResponses = map[int]interface{}{
+	http.StatusOK:                    UploadResponse{},
+	http.StatusMultiStatus:           UploadResponse{},
+	http.StatusBadRequest:            ErrorResponse{},
+	http.StatusRequestEntityTooLarge: ErrorResponse{},
+	http.StatusUnsupportedMediaType:  ErrorResponse{},
+	http.StatusUnprocessableEntity:   ErrorResponse{},
+	http.StatusInternalServerError:   ErrorResponse{},
+	http.StatusServiceUnavailable:    ErrorResponse{},
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+)
+
+// OpenAPIHandler serves the OpenAPI 3 description of the API. Schemas are
+// generated from the Go types and the route table, so the document cannot
+// drift from what the handlers send.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		var operations []apiOperation
+		for _, rt := range apiRoutes() {
+			operations = append(operations, rt.apiOperation)
+			if rt.Legacy != "" {
+				legacy := rt.apiOperation
+				legacy.Path = rt.Legacy
+				legacy.Deprecated = true
+				operations = append(operations, legacy)
+			}
+			if rt.Alias != "" {
+				alias := rt.apiOperation
+				alias.Path = rt.Alias
+				operations = append(operations, alias)
+			}
+		}
+		openAPIDocument, _ = json.MarshalIndent(buildOpenAPI(operations), "", "  ")
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// buildOpenAPI assembles the document for the given operations
+func buildOpenAPI(operations []apiOperation) map[string]interface{} {
+	schemas := &schemaRegistry{components: map[string]interface{}{}}
+	paths := map[string]map[string]interface{}{}
+
+	for _, op := range operations {
+		operation := map[string]interface{}{
+			"summary":   op.Summary,
+			"responses": map[string]interface{}{},
+		}
+		if op.Deprecated {
+			operation["deprecated"] = true
+		}
+
+		var parameters []interface{}
+		for _, name := range pathParameters(op.Path) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, name := range sortedKeys(op.Query) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        name,
+				"in":          "query",
+				"required":    false,
+				"description": op.Query[name],
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+
+		for _, name := range sortedKeys(op.Headers) {
+			parameters = append(parameters, headerParameter(name, op.Headers[name]))
+		}
+
+		content := map[string]interface{}{}
+		if op.Multipart {
+			field := op.FileField
+			if field == "" {
+				field = "image"
+			}
+			file := map[string]interface{}{"type": "string", "format": "binary"}
+			if !op.SingleFile {
+				file = map[string]interface{}{"type": "array", "items": file}
+			}
+			content["multipart/form-data"] = map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type":       "object",
+					"required":   []string{field},
+					"properties": map[string]interface{}{field: file},
+				},
+			}
+			parameters = append(parameters,
+				headerParameter("X-Tenant-ID", "Tenant the upload belongs to"),
+				headerParameter("X-Upload-Profile", "S3 upload profile applied to the variants"),
+			)
+		}
+		if op.RawImage {
+			content["image/*"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}
+			parameters = append(parameters, headerParameter("X-Filename", "Name of a raw image body; the filename query parameter works too"))
+		}
+		if op.RequestBody != nil {
+			content["application/json"] = map[string]interface{}{"schema": schemas.schemaFor(reflect.TypeOf(op.RequestBody))}
+		}
+		if len(content) > 0 {
+			operation["requestBody"] = map[string]interface{}{"required": true, "content": content}
+		}
+
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		responses := operation["responses"].(map[string]interface{})
+		for status, body := range op.Responses {
+			response := map[string]interface{}{"description": http.StatusText(status)}
+			// A nil body documents a response without content
+			if media, ok := body.(binaryBody); ok {
+				response["content"] = map[string]interface{}{
+					string(media): map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+				}
+			} else if body != nil {
+				response["content"] = map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.schemaFor(reflect.TypeOf(body))},
+				}
+			}
+			responses[strconv.Itoa(status)] = response
+		}
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "CompressImage API",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas.components},
+	}
+}
+
+// pathParameters returns the names of the {wildcards} in a route path
+func pathParameters(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, strings.TrimSuffix(strings.Trim(segment, "{}"), "..."))
+		}
+	}
+	return names
+}
+
+// sortedKeys returns the keys of m in order, keeping the document stable
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// headerParameter documents an optional request header
+func headerParameter(name, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "header",
+		"required":    false,
+		"description": description,
+		"schema":      map[string]interface{}{"type": "string"},
+	}
+}
+
+// schemaRegistry turns Go types into JSON schemas, placing named structs in
+// the components section and referring to them from everywhere else
+type schemaRegistry struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// modulePath is the import path of this module, left out of component names
+var modulePath = strings.TrimSuffix(reflect.TypeOf(apiOperation{}).PkgPath(), "/internal/handler")
+
+// componentName names the schema of a struct after its package and type, such
+// as handler.UploadResponse, so same-named types of two packages stay apart.
+// Packages of this module are named by their path inside it.
+func componentName(t reflect.Type) string {
+	pkg := strings.TrimPrefix(strings.TrimPrefix(t.PkgPath(), modulePath), "/")
+	pkg = strings.TrimPrefix(pkg, "internal/")
+	return strings.ReplaceAll(pkg, "/", ".") + "." + t.Name()
+}
+
+// schemaFor returns the schema of t, following the encoding/json rules
+func (s *schemaRegistry) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() == "":
+		return s.structSchema(t)
+	case t.Kind() == reflect.Struct:
+		name := componentName(t)
+		if _, ok := s.components[name]; !ok {
+			// Register first so self-referencing types terminate
+			s.components[name] = map[string]interface{}{}
+			s.components[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Uint, reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema describes the JSON fields of a struct
+func (s *schemaRegistry) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for _, field := range jsonFields(t) {
+		properties[field.name] = s.schemaFor(field.typ)
+		if field.required {
+			required = append(required, field.name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// jsonField is a field as encoding/json sees it
+type jsonField struct {
+	name     string
+	typ      reflect.Type
+	depth    int  // How deep in embedded structs the field is
+	tagged   bool // The name comes from a json tag
+	required bool
+}
+
+// jsonFields returns the fields encoding/json writes for a struct, with the
+// fields of embedded structs promoted like encoding/json does: a shallower
+// field hides deeper ones of the same name, and of several at the same depth
+// only a single tagged one is kept.
+func jsonFields(t reflect.Type) []jsonField {
+	var all []jsonField
+	collectJSONFields(t, 0, true, map[reflect.Type]bool{}, &all)
+
+	byName := map[string][]jsonField{}
+	var names []string
+	for _, field := range all {
+		if byName[field.name] == nil {
+			names = append(names, field.name)
+		}
+		byName[field.name] = append(byName[field.name], field)
+	}
+
+	var fields []jsonField
+	for _, name := range names {
+		if field, ok := dominantField(byName[name]); ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// collectJSONFields adds the fields of t at depth, descending into embedded
+// structs. Fields reached through an embedded pointer are never required.
+func collectJSONFields(t reflect.Type, depth int, required bool, visiting map[reflect.Type]bool, fields *[]jsonField) {
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name, options, _ := strings.Cut(tag, ",")
+		if tag == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			// Unexported embedded structs still promote their exported fields
+			if embedded.Kind() == reflect.Struct && (field.IsExported() || field.Type.Kind() != reflect.Ptr) {
+				collectJSONFields(embedded, depth+1, required && field.Type.Kind() != reflect.Ptr, visiting, fields)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = field.Name
+		}
+		*fields = append(*fields, jsonField{
+			name:     name,
+			typ:      field.Type,
+			depth:    depth,
+			tagged:   tagged,
+			required: required && !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr,
+		})
+	}
+}
+
+// dominantField picks the field encoding/json writes among those sharing a
+// name, reporting false when they cancel each other out
+func dominantField(fields []jsonField) (jsonField, bool) {
+	depth := fields[0].depth
+	for _, field := range fields {
+		depth = min(depth, field.depth)
+	}
+
+	var shallowest, tagged []jsonField
+	for _, field := range fields {
+		if field.depth == depth {
+			shallowest = append(shallowest, field)
+			if field.tagged {
+				tagged = append(tagged, field)
+			}
+		}
+	}
+	switch {
+	case len(shallowest) == 1:
+		return shallowest[0], true
+	case len(tagged) == 1:
+		return tagged[0], true
+	default:
+		return jsonField{}, false
+	}
+}
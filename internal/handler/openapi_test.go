@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+type embeddedBase struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Created string `json:"created,omitempty"`
+}
+
+type EmbeddedExtra struct {
+	Name  string `json:"name"`
+	Note  string `json:"note"`
+	Owner string
+}
+
+type embeddedOuter struct {
+	embeddedBase
+	*EmbeddedExtra
+	Name   string `json:"name"` // Hides the names of both embedded structs
+	Tagged struct {
+		Value int `json:"value"`
+	} `json:"tagged"`
+}
+
+func TestStructSchemaFlattensEmbeddedStructs(t *testing.T) {
+	schemas := &schemaRegistry{components: map[string]interface{}{}}
+	schema := schemas.structSchema(reflect.TypeOf(embeddedOuter{}))
+
+	properties := schema["properties"].(map[string]interface{})
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	want := []string{"Owner", "created", "id", "name", "note", "tagged"}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("properties are %v, want %v", names, want)
+	}
+	if _, ok := properties["tagged"].(map[string]interface{})["properties"]; !ok {
+		t.Errorf("anonymous struct field is %v, want it described inline", properties["tagged"])
+	}
+
+	// Fields behind the embedded pointer may be missing
+	if got := schema["required"]; !reflect.DeepEqual(got, []string{"id", "name", "tagged"}) {
+		t.Errorf("required fields are %v, want id, name and tagged", got)
+	}
+}
+
+func TestSchemaForQualifiesComponentNames(t *testing.T) {
+	schemas := &schemaRegistry{components: map[string]interface{}{}}
+	tests := []struct {
+		typ  interface{}
+		want string
+	}{
+		{ErrorResponse{}, "#/components/schemas/handler.ErrorResponse"},
+		{service.BulkReport{}, "#/components/schemas/service.BulkReport"},
+	}
+	for _, tt := range tests {
+		if got := schemas.schemaFor(reflect.TypeOf(tt.typ))["$ref"]; got != tt.want {
+			t.Errorf("schema of %T refers to %v, want %s", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestOpenAPIDocumentsSingleFileUploads(t *testing.T) {
+	var operations []apiOperation
+	for _, rt := range apiRoutes() {
+		operations = append(operations, rt.apiOperation)
+	}
+	paths := buildOpenAPI(operations)["paths"].(map[string]map[string]interface{})
+
+	tests := []struct {
+		path  string
+		field string
+		want  string
+	}{
+		{"/v1/images", "image", "array"},
+		{"/v1/s3/images", "image", "string"},
+		{"/v1/jobs", "image", "string"},
+		{"/v1/archives", "archive", "string"},
+	}
+	for _, tt := range tests {
+		operation := paths[tt.path]["post"].(map[string]interface{})
+		content := operation["requestBody"].(map[string]interface{})["content"].(map[string]interface{})
+		schema := content["multipart/form-data"].(map[string]interface{})["schema"].(map[string]interface{})
+		field := schema["properties"].(map[string]interface{})[tt.field].(map[string]interface{})
+		if field["type"] != tt.want {
+			t.Errorf("%s field %s is a %v, want a %s", tt.path, tt.field, field["type"], tt.want)
+		}
+	}
+}
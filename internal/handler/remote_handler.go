@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// RemoteImageHandler downloads an image from the URL in the request body and
+// stores its variants like an uploaded file. Addresses inside private,
+// loopback and link-local networks are refused.
+func RemoteImageHandler(w http.ResponseWriter, r *http.Request) {
+	var req RemoteImageRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxFieldBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, service.ErrCodeInvalidRequest, "Invalid request body", err)
+		return
+	}
+	if req.URL == "" {
+		writeError(w, r, service.ErrCodeInvalidRequest, "url is required", nil)
+		return
+	}
+	store, err := parseStore(req.Store)
+	if err != nil {
+		writeServiceError(w, r, err, "Invalid store")
+		return
+	}
+	if _, ok := service.GetS3Profile(r.Header.Get("X-Upload-Profile")); !ok {
+		writeError(w, r, service.ErrCodeUnknownProfile, "Unknown upload profile", nil)
+		return
+	}
+
+	remote, err := service.FetchRemoteImage(r.Context(), req.URL)
+	if err != nil {
+		writeServiceError(w, r, err, "Failed to download image")
+		return
+	}
+	imgConfig, err := service.CheckImage(remote.Data)
+	if err != nil {
+		writeServiceError(w, r, err, "Failed to decode image")
+		return
+	}
+
+	writeUploadResponse(w, r, []ImageResponse{processImage(r, nil, 0, store, remote.Filename, remote.Data, imgConfig)})
+}
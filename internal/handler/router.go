@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// route binds a method and path to a handler. The embedded operation documents
+// it in the OpenAPI document.
+type route struct {
+	apiOperation
+	Legacy  string // Deprecated path of the API before /v1, served by the same handler
+	Alias   string // Unversioned path served by the same handler, not deprecated
+	Handler http.HandlerFunc
+}
+
+// apiRoutes is the route table of the API
+func apiRoutes() []route {
+	return []route{
+		{
+			apiOperation: apiOperation{
+				Method:      http.MethodPost,
+				Path:        "/v1/images",
+				Summary:     "Upload one or more images and store their variants locally",
+				Multipart:   true,
+				RawImage:    true,
+				RequestBody: JSONUploadRequest{},
+				Query: map[string]string{
+					"stream":         "Stream progress events instead of a single reply: sse or ndjson",
+					"all_or_nothing": "With true, nothing is stored unless every file is a valid image",
+					"filename":       "Name of a raw image body, when the X-Filename header is not sent",
+				},
+				Responses: uploadResponses,
+			},
+			Legacy:  "/upload",
+			Handler: UploadImageHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:     http.MethodPost,
+				Path:       "/v1/s3/images",
+				Summary:    "Upload an image and store its variants in S3",
+				Multipart:  true,
+				SingleFile: true,
+				Responses:  uploadResponses,
+			},
+			Legacy:  "/s3upload",
+			Handler: S3ImageHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:      http.MethodPost,
+				Path:        "/v1/images/url",
+				Summary:     "Download an image from a URL and store its variants",
+				RequestBody: RemoteImageRequest{},
+				Responses: map[int]interface{}{
+					http.StatusOK:                    UploadResponse{},
+					http.StatusMultiStatus:           UploadResponse{},
+					http.StatusBadRequest:            ErrorResponse{},
+					http.StatusRequestEntityTooLarge: ErrorResponse{},
+					http.StatusUnsupportedMediaType:  ErrorResponse{},
+					http.StatusUnprocessableEntity:   ErrorResponse{},
+					http.StatusInternalServerError:   ErrorResponse{},
+					http.StatusBadGateway:            ErrorResponse{},
+					http.StatusServiceUnavailable:    ErrorResponse{},
+				},
+			},
+			Handler: RemoteImageHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodGet,
+				Path:    "/v1/images/{id}/archive",
+				Summary: "Download the uploaded file and the stored variants of an image as a ZIP archive",
+				Headers: map[string]string{"X-Tenant-ID": "Tenant the image belongs to"},
+				Responses: map[int]interface{}{
+					http.StatusOK:                  binaryBody("application/zip"),
+					http.StatusNotFound:            ErrorResponse{},
+					http.StatusInternalServerError: ErrorResponse{},
+					http.StatusServiceUnavailable:  ErrorResponse{},
+				},
+			},
+			Handler: ImageArchiveHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:     http.MethodPost,
+				Path:       "/v1/archives",
+				Summary:    "Upload a ZIP archive and store the variants of every image in it under its archive path",
+				Multipart:  true,
+				FileField:  "archive",
+				SingleFile: true,
+				Query: map[string]string{
+					"store":  "Where the variants are stored: local (default) or s3",
+					"stream": "Stream progress events instead of a single reply: sse or ndjson",
+				},
+				Responses: uploadResponses,
+			},
+			Handler: ArchiveUploadHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:      http.MethodPost,
+				Path:        "/v1/s3/process",
+				Summary:     "Process every image already stored under an S3 prefix",
+				RequestBody: S3BulkRequest{},
+				Query: map[string]string{
+					"stream": "Stream a progress event per object and the report as the last event: sse or ndjson",
+				},
+				Responses: map[int]interface{}{
+					http.StatusOK:                  service.BulkReport{},
+					http.StatusBadRequest:          ErrorResponse{},
+					http.StatusServiceUnavailable:  ErrorResponse{},
+					http.StatusInternalServerError: ErrorResponse{},
+				},
+			},
+			Handler: S3BulkHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:     http.MethodPost,
+				Path:       "/v1/jobs",
+				Summary:    "Queue an image for processing and return the job to poll",
+				Multipart:  true,
+				SingleFile: true,
+				Query: map[string]string{
+					"store":        "Where the variants are stored: local (default) or s3",
+					"callback_url": "URL receiving a signed webhook once the job finishes",
+				},
+				Responses: map[int]interface{}{
+					http.StatusAccepted:              JobResponse{},
+					http.StatusBadRequest:            ErrorResponse{},
+					http.StatusRequestEntityTooLarge: ErrorResponse{},
+					http.StatusUnsupportedMediaType:  ErrorResponse{},
+					http.StatusUnprocessableEntity:   ErrorResponse{},
+					http.StatusServiceUnavailable:    ErrorResponse{},
+				},
+			},
+			Handler: CreateJobHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodGet,
+				Path:    "/v1/jobs/{id}",
+				Summary: "Report the progress and results of a job",
+				Responses: map[int]interface{}{
+					http.StatusOK:       JobResponse{},
+					http.StatusNotFound: ErrorResponse{},
+				},
+			},
+			Handler: JobStatusHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodDelete,
+				Path:    "/v1/jobs/{id}",
+				Summary: "Remove a finished job and its stored input",
+				Responses: map[int]interface{}{
+					http.StatusNoContent:  nil,
+					http.StatusBadRequest: ErrorResponse{},
+					http.StatusNotFound:   ErrorResponse{},
+				},
+			},
+			Handler: DeleteJobHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodGet,
+				Path:    "/v1/jobs/dead-letters",
+				Summary: "List the jobs that failed for good and can be replayed",
+				Responses: map[int]interface{}{
+					http.StatusOK: []JobResponse{},
+				},
+			},
+			Handler: DeadLettersHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodPost,
+				Path:    "/v1/jobs/{id}/replay",
+				Summary: "Queue a failed job again",
+				Responses: map[int]interface{}{
+					http.StatusAccepted:   JobResponse{},
+					http.StatusBadRequest: ErrorResponse{},
+					http.StatusNotFound:   ErrorResponse{},
+				},
+			},
+			Handler: ReplayJobHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodGet,
+				Path:    "/v1/jobs/{id}/webhooks",
+				Summary: "List the webhook deliveries of a job",
+				Responses: map[int]interface{}{
+					http.StatusOK:       []service.WebhookDelivery{},
+					http.StatusNotFound: ErrorResponse{},
+				},
+			},
+			Handler: JobWebhooksHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodGet,
+				Path:    "/v1/webhooks/deliveries",
+				Summary: "List recent webhook deliveries, newest first",
+				Query: map[string]string{
+					"job_id": "Only list deliveries of this job",
+					"status": "Only list deliveries in this state: pending, delivered or failed",
+				},
+				Responses: map[int]interface{}{
+					http.StatusOK: []service.WebhookDelivery{},
+				},
+			},
+			Handler: WebhookDeliveriesHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodGet,
+				Path:    "/v1/cache/stats",
+				Summary: "Report processing cache counters",
+				Responses: map[int]interface{}{
+					http.StatusOK: service.CacheStats{},
+				},
+			},
+			Handler: CacheStatsHandler,
+		},
+		{
+			apiOperation: apiOperation{
+				Method:  http.MethodGet,
+				Path:    "/v1/openapi.json",
+				Summary: "This OpenAPI document",
+				Responses: map[int]interface{}{
+					http.StatusOK: map[string]interface{}{},
+				},
+			},
+			Alias:   "/openapi.json",
+			Handler: OpenAPIHandler,
+		},
+	}
+}
+
+// NewRouter registers every route on a new mux. Each path only accepts its
+// listed methods and answers anything else with 405 and an Allow header.
+// Legacy paths keep working but mark their responses as deprecated; aliases
+// are plain second paths.
+func NewRouter() http.Handler {
+	methods := map[string]map[string]http.HandlerFunc{}
+	var paths []string
+	add := func(path, method string, h http.HandlerFunc) {
+		if methods[path] == nil {
+			methods[path] = map[string]http.HandlerFunc{}
+			paths = append(paths, path)
+		}
+		methods[path][method] = h
+	}
+
+	for _, rt := range apiRoutes() {
+		add(rt.Path, rt.Method, rt.Handler)
+		if rt.Legacy != "" {
+			add(rt.Legacy, rt.Method, deprecated(rt.Path, rt.Handler))
+		}
+		if rt.Alias != "" {
+			add(rt.Alias, rt.Method, rt.Handler)
+		}
+	}
+
+	mux := http.NewServeMux()
+	for _, path := range paths {
+		mux.HandleFunc(path, dispatch(methods[path]))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, service.ErrCodeNotFound, "Route not found", nil)
+	})
+	return mux
+}
+
+// dispatch calls the handler registered for the request method
+func dispatch(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	allowed := make([]string, 0, len(handlers)+1)
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	if _, ok := handlers[http.MethodGet]; ok {
+		allowed = append(allowed, http.MethodHead)
+	}
+	sort.Strings(allowed)
+	allow := strings.Join(allowed, ", ")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		h, ok := handlers[method]
+		if !ok {
+			w.Header().Set("Allow", allow)
+			writeError(w, r, service.ErrCodeMethodNotAllowed, "Method not allowed", nil)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// deprecated marks responses of a legacy path and points to its successor
+func deprecated(successor string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		h(w, r)
+	}
+}
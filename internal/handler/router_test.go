@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterLegacyPaths(t *testing.T) {
+	router := NewRouter()
+	tests := []struct {
+		method     string
+		path       string
+		status     int // 0 when any status but 404 will do
+		deprecated bool
+	}{
+		{http.MethodGet, "/v1/openapi.json", http.StatusOK, false},
+		{http.MethodGet, "/openapi.json", http.StatusOK, false},
+		{http.MethodPost, "/upload", 0, true},
+		{http.MethodPost, "/s3upload", 0, true},
+		// Only paths of the API before /v1 are kept
+		{http.MethodPost, "/s3process", http.StatusNotFound, false},
+		{http.MethodGet, "/cache/stats", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if tt.status != 0 && w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == 0 && w.Code == http.StatusNotFound {
+				t.Error("route not found")
+			}
+			if got := w.Header().Get("Deprecation") != ""; got != tt.deprecated {
+				t.Errorf("Deprecation header %q, want deprecated %v", w.Header().Get("Deprecation"), tt.deprecated)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Stream formats a client can ask for with the stream query parameter or the Accept header
+const (
+	streamSSE    = "sse"
+	streamNDJSON = "ndjson"
+)
+
+// Progress events sent while an upload or bulk run is streamed
+const (
+	eventFileStarted = "file_started"
+	eventVariant     = "variant"
+	eventFileDone    = "file_done"
+	eventProgress    = "progress" // An object of a bulk run is done
+	eventError       = "error"
+	eventDone        = "done"
+)
+
+// eventStream writes progress events as Server-Sent Events or as JSON lines.
+// It is safe for concurrent use, since variants finish on their own goroutines.
+type eventStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	r       *http.Request
+	format  string
+	flusher http.Flusher
+}
+
+// newEventStream starts a stream if the client asked for one, and returns nil otherwise
+func newEventStream(w http.ResponseWriter, r *http.Request) *eventStream {
+	format := r.URL.Query().Get("stream")
+	if format == "" {
+		accept := r.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, "text/event-stream"):
+			format = streamSSE
+		case strings.Contains(accept, "application/x-ndjson"):
+			format = streamNDJSON
+		}
+	}
+	if format != streamSSE && format != streamNDJSON {
+		return nil
+	}
+
+	if format == streamSSE {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep proxies from holding events back
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	return &eventStream{w: w, r: r, format: format, flusher: flusher}
+}
+
+// send writes one event and flushes it to the client
+func (s *eventStream) send(event string, data interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event, err)
+		return
+	}
+	if s.format == streamSSE {
+		_, err = s.w.Write([]byte("event: " + event + "\ndata: " + string(payload) + "\n\n"))
+	} else {
+		line, _ := json.Marshal(StreamEvent{Event: event, Data: payload})
+		_, err = s.w.Write(append(line, '\n'))
+	}
+	if err != nil {
+		return // The client went away; the request context reports it
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// fail sends an error event; the cause is only logged, as with writeError
+func (s *eventStream) fail(code, message string, cause error) {
+	if cause != nil {
+		log.Printf("%s %s: %s (%s): %v", s.r.Method, s.r.URL.Path, message, code, cause)
+	}
+	s.send(eventError, ErrorResponse{Error: ErrorBody{Code: code, Message: message}})
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/abhinandpn/CompressImage/internal/service"
+)
+
+// readImageUpload reads the images of an upload sent as a multipart form, as
+// a raw image body or as JSON with base64 content. Every kind ends up as the
+// same upload form, with the same limits and checks applied to its files.
+func readImageUpload(w http.ResponseWriter, r *http.Request, limits uploadLimits) (*uploadForm, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		// Left to the multipart reader, which also takes multipart/mixed
+		return readUploadForm(w, r, "image", limits)
+	case strings.HasPrefix(mediaType, "image/"):
+		return readRawUpload(w, r, limits)
+	case mediaType == "application/json":
+		return readJSONUpload(w, r, limits)
+	}
+	return nil, service.NewError(service.ErrCodeUnsupportedFormat, "Content-Type must be multipart/form-data, application/json or image/*", nil)
+}
+
+// readRawUpload reads a request whose body is the image itself. It is named
+// by the X-Filename header or the filename query parameter.
+func readRawUpload(w http.ResponseWriter, r *http.Request, limits uploadLimits) (*uploadForm, error) {
+	if r.ContentLength > limits.MaxRequestBytes {
+		return nil, &http.MaxBytesError{Limit: limits.MaxRequestBytes}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxRequestBytes)
+
+	filename := r.Header.Get("X-Filename")
+	if filename == "" {
+		filename = r.URL.Query().Get("filename")
+	}
+
+	form := newUploadForm(r)
+	memory := limits.MaxMemoryBytes
+	file, err := form.readFile(r.Body, uploadFilename(filename, 0), limits, &memory)
+	if err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+	form.Files = append(form.Files, file)
+	return form, nil
+}
+
+// readJSONUpload reads a JSONUploadRequest. Content that is not valid base64
+// only fails its own file, like a file that is not an image.
+func readJSONUpload(w http.ResponseWriter, r *http.Request, limits uploadLimits) (*uploadForm, error) {
+	if r.ContentLength > limits.MaxRequestBytes {
+		return nil, &http.MaxBytesError{Limit: limits.MaxRequestBytes}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxRequestBytes)
+
+	var req JSONUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		// A body over the limit is still reported as too large
+		return nil, service.NewError(service.ErrCodeInvalidRequest, "Invalid request body", err)
+	}
+	if len(req.Images) > limits.MaxFiles {
+		return nil, service.NewError(service.ErrCodeTooLarge, fmt.Sprintf("An upload may contain at most %d files", limits.MaxFiles), nil)
+	}
+
+	form := newUploadForm(r)
+	memory := limits.MaxMemoryBytes
+	for i := range req.Images {
+		filename := uploadFilename(req.Images[i].Filename, i)
+		data, err := decodeImageContent(req.Images[i].Content, limits.MaxFileBytes)
+		// The encoded copy is no longer needed once decoded
+		req.Images[i].Content = ""
+		if err != nil {
+			form.Files = append(form.Files, &uploadedFile{Filename: filename, err: err})
+			continue
+		}
+
+		file, err := form.readFile(bytes.NewReader(data), filename, limits, &memory)
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+		form.Files = append(form.Files, file)
+	}
+	return form, nil
+}
+
+// decodeImageContent decodes base64 content, which may be wrapped in a data
+// URI and broken into lines. Content decoding to more than maxBytes is
+// refused before it is decoded.
+func decodeImageContent(content string, maxBytes int64) ([]byte, error) {
+	if strings.HasPrefix(content, "data:") {
+		header, data, ok := strings.Cut(content, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return nil, service.NewError(service.ErrCodeInvalidRequest, "Data URI content must be base64 encoded", nil)
+		}
+		content = data
+	}
+	// Encoders such as base64(1) wrap their output into lines
+	content = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, content)
+	if content == "" {
+		return nil, service.NewError(service.ErrCodeInvalidRequest, "Image content is empty", nil)
+	}
+
+	// Unpadded base64 is accepted as well
+	unpadded := strings.TrimRight(content, "=")
+	if int64(base64.RawStdEncoding.DecodedLen(len(unpadded))) > maxBytes {
+		return nil, service.NewError(service.ErrCodeTooLarge, fmt.Sprintf("File size exceeds %d bytes", maxBytes), nil)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(unpadded)
+	if err != nil {
+		return nil, service.NewError(service.ErrCodeInvalidRequest, "Image content is not valid base64", err)
+	}
+	return data, nil
+}
+
+// uploadFilename keeps the last element of a client supplied name, like
+// multipart file names, and names unnamed images by their position
+func uploadFilename(name string, index int) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return fmt.Sprintf("image-%d", index+1)
+	}
+	return name
+}
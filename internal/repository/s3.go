@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3Client is the shared client returned by S3Client
+var (
+	s3ClientMu sync.Mutex
+	s3Client   *s3.S3
+)
+
+// S3Client returns the S3 client for the AWS settings in the environment. It
+// is created on first use and shared, so requests reuse its session and
+// connections. Creating it is tried again after a failure.
+func S3Client() (*s3.S3, error) {
+	s3ClientMu.Lock()
+	defer s3ClientMu.Unlock()
+	if s3Client != nil {
+		return s3Client, nil
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(config.GetAWSRegion()),
+		Credentials: credentials.NewStaticCredentials(config.GetAWSAccessKey(), config.GetAWSSecretKey(), ""),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AWS session: %v", err)
+	}
+	s3Client = s3.New(sess)
+	return s3Client, nil
+}
+
+// S3Object describes an object listed from the bucket
+type S3Object struct {
+	Key          string
+	ETag         string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListS3Objects returns every object under the given prefix
+func ListS3Objects(prefix string) ([]S3Object, error) {
+	svc, err := S3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []S3Object
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(config.GetAWSBucketName()),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, S3Object{
+				Key:          aws.StringValue(obj.Key),
+				ETag:         aws.StringValue(obj.ETag),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %v", err)
+	}
+	return objects, nil
+}
+
+// ErrObjectTooLarge is returned when an object exceeds the size a caller accepts
+var ErrObjectTooLarge = errors.New("object is too large")
+
+// DownloadS3Object reads an object of at most maxBytes from the bucket into
+// memory. Larger objects fail with ErrObjectTooLarge once maxBytes are read.
+func DownloadS3Object(ctx context.Context, key string, maxBytes int64) ([]byte, error) {
+	svc, err := S3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(config.GetAWSBucketName()),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object %s: %v", key, err)
+	}
+	defer out.Body.Close()
+
+	// The object may have grown since it was listed
+	data, err := io.ReadAll(io.LimitReader(out.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object %s: %v", key, err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, ErrObjectTooLarge
+	}
+	return data, nil
+}
+
+// S3ObjectExists reports whether an object with the given key is in the bucket
+func S3ObjectExists(key string) (bool, error) {
+	svc, err := S3Client()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(config.GetAWSBucketName()),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 404 {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check object %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// OpenS3Object starts reading an object of bucket; the caller must close the body
+func OpenS3Object(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	svc, err := S3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 404 {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to download object %s: %v", key, err)
+	}
+	return out.Body, nil
+}
+
+// CopyS3Object copies an object of the bucket to another key without
+// downloading it. configure, if not nil, can set additional object settings.
+func CopyS3Object(ctx context.Context, srcKey, dstKey string, configure func(*s3.CopyObjectInput)) error {
+	svc, err := S3Client()
+	if err != nil {
+		return err
+	}
+
+	bucket := config.GetAWSBucketName()
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String((&url.URL{Path: bucket + "/" + srcKey}).EscapedPath()),
+	}
+	if configure != nil {
+		configure(input)
+	}
+	if _, err := svc.CopyObjectWithContext(ctx, input); err != nil {
+		return fmt.Errorf("failed to copy object %s to %s: %v", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// PutS3Object writes a small object to the bucket in a single request
+func PutS3Object(ctx context.Context, key string, data []byte, contentType string) error {
+	svc, err := S3Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(config.GetAWSBucketName()),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload object %s: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/repository"
+)
+
+// Bulk object statuses reported while processing a prefix
+const (
+	BulkStatusProcessed = "processed"
+	BulkStatusSkipped   = "skipped"
+	BulkStatusFailed    = "failed"
+)
+
+// BulkObjectResult is the outcome of processing a single source object
+type BulkObjectResult struct {
+	Key       string          `json:"key"`
+	Status    string          `json:"status"`
+	Variants  []VariantResult `json:"variants,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	ErrorCode string          `json:"error_code,omitempty"`
+}
+
+// BulkReport summarises a run over an S3 prefix
+type BulkReport struct {
+	SourcePrefix string             `json:"source_prefix"`
+	DestPrefix   string             `json:"dest_prefix"`
+	Total        int                `json:"total"`
+	Processed    int                `json:"processed"`
+	Skipped      int                `json:"skipped"`
+	Failed       int                `json:"failed"`
+	Objects      []BulkObjectResult `json:"objects"`
+}
+
+// ProcessS3Prefix runs every image under sourcePrefix through the variant pipeline
+// and writes the variants under destPrefix. Objects whose variants already exist
+// are skipped, so an interrupted run can simply be started again; objects an
+// earlier run finished are skipped without being downloaded. progress, if
+// not nil, is called after each object. Cancelling ctx stops the run after
+// aborting the uploads in progress.
+func ProcessS3Prefix(ctx context.Context, opts UploadOptions, sourcePrefix, destPrefix string, progress func(done, total int, result BulkObjectResult)) (*BulkReport, error) {
+	profile, ok := GetS3Profile(opts.Profile)
+	if !ok {
+		return nil, NewError(ErrCodeUnknownProfile, fmt.Sprintf("Unknown upload profile %q", opts.Profile), nil)
+	}
+
+	objects, err := repository.ListS3Objects(sourcePrefix)
+	if err != nil {
+		return nil, NewError(ErrCodeStorageUnavailable, "Failed to list S3 objects", err)
+	}
+
+	// Only originals are processed, never our own output
+	var sources []repository.S3Object
+	for _, object := range objects {
+		if destPrefix != "" && strings.HasPrefix(object.Key, destPrefix) {
+			continue
+		}
+		if !isImageKey(object.Key) {
+			continue
+		}
+		sources = append(sources, object)
+	}
+
+	report := &BulkReport{
+		SourcePrefix: sourcePrefix,
+		DestPrefix:   destPrefix,
+		Total:        len(sources),
+	}
+
+	for i, source := range sources {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		result := processS3Object(ctx, opts.Tenant, profile, source, sourcePrefix, destPrefix)
+		switch result.Status {
+		case BulkStatusProcessed:
+			report.Processed++
+		case BulkStatusSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+		report.Objects = append(report.Objects, result)
+
+		if progress != nil {
+			progress(i+1, len(sources), result)
+		}
+	}
+
+	return report, nil
+}
+
+// bulkMarker records that every variant of a source object was uploaded, so
+// later runs can skip the object without downloading it
+type bulkMarker struct {
+	SourceKey string    `json:"source_key"`
+	ETag      string    `json:"etag"`
+	Hash      string    `json:"hash"`
+	BaseName  string    `json:"base_name"`
+	Time      time.Time `json:"time"`
+}
+
+// bulkMarkerKey returns where the marker of a source object is kept. Markers
+// live below the destination prefix, per tenant.
+func bulkMarkerKey(destPrefix, tenant, sourceKey string) string {
+	sum := sha256.Sum256([]byte(tenant + "/" + sourceKey))
+	return destPrefix + ".bulk/" + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// processS3Object downloads a single object and uploads its variants. Objects
+// marked as done by an earlier run are skipped without being downloaded.
+func processS3Object(ctx context.Context, tenant string, profile S3Profile, source repository.S3Object, sourcePrefix, destPrefix string) BulkObjectResult {
+	key := source.Key
+	result := BulkObjectResult{Key: key}
+
+	// Keep the folder structure below the source prefix in the output keys
+	relative := strings.TrimPrefix(strings.TrimPrefix(key, sourcePrefix), "/")
+	baseName := strings.TrimSuffix(relative, path.Ext(relative))
+	baseName = strings.ReplaceAll(baseName, " ", "_")
+
+	markerKey := bulkMarkerKey(destPrefix, tenant, key)
+	if marker, ok := readBulkMarker(ctx, markerKey); ok && marker.ETag == source.ETag && marker.BaseName == baseName {
+		params := KeyParams{Tenant: tenant, BaseName: baseName, Ext: "jpg", Hash: marker.Hash, Time: marker.Time}
+		done, err := variantsExist(destPrefix, params)
+		if err != nil {
+			return result.fail(NewError(ErrCodeStorageUnavailable, "Failed to check for existing variants", err))
+		}
+		if done {
+			result.Status = BulkStatusSkipped
+			return result
+		}
+	}
+
+	// Keys depend on the image content, so it is needed before checking for
+	// variants. Objects over the upload limit are refused without downloading them.
+	maxBytes := config.GetUploadMaxFileBytes()
+	tooLarge := NewError(ErrCodeTooLarge, fmt.Sprintf("Object size exceeds %d bytes", maxBytes), nil)
+	if source.Size > maxBytes {
+		return result.fail(tooLarge)
+	}
+	imageData, err := repository.DownloadS3Object(ctx, key, maxBytes)
+	if errors.Is(err, repository.ErrObjectTooLarge) {
+		return result.fail(tooLarge)
+	}
+	if err != nil {
+		return result.fail(NewError(ErrCodeStorageUnavailable, "Failed to download object", err))
+	}
+	params := NewKeyParams(tenant, baseName, imageData)
+	// The object date keeps keys stable across runs on different days
+	params.Time = source.LastModified.UTC()
+	marker := bulkMarker{SourceKey: key, ETag: source.ETag, Hash: params.Hash, BaseName: baseName, Time: params.Time}
+
+	done, err := variantsExist(destPrefix, params)
+	if err != nil {
+		return result.fail(NewError(ErrCodeStorageUnavailable, "Failed to check for existing variants", err))
+	}
+	if done {
+		writeBulkMarker(ctx, markerKey, marker)
+		result.Status = BulkStatusSkipped
+		return result
+	}
+
+	imgConfig, err := CheckImage(imageData)
+	if err != nil {
+		return result.fail(err)
+	}
+
+	processed := ImageResult{Variants: s3ProcessVariants(ctx, params, profile, imageData, variantSpecs(int64(len(imageData)), imgConfig.Width, imgConfig.Height), imgConfig.Width, imgConfig.Height, destPrefix, nil)}
+	result.Variants = processed.Variants
+	if failed := processed.Failed(); len(failed) > 0 {
+		message := fmt.Sprintf("%d of %d variants failed", len(failed), len(processed.Variants))
+		return result.fail(NewError(processed.FailureCode(), message, nil))
+	}
+
+	writeBulkMarker(ctx, markerKey, marker)
+	result.Status = BulkStatusProcessed
+	return result
+}
+
+// readBulkMarker reads the marker of a source object, if there is a usable one
+func readBulkMarker(ctx context.Context, key string) (bulkMarker, bool) {
+	body, err := repository.OpenS3Object(ctx, config.GetAWSBucketName(), key)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read bulk marker %s: %v", key, err)
+		}
+		return bulkMarker{}, false
+	}
+	defer body.Close()
+
+	var marker bulkMarker
+	if err := json.NewDecoder(io.LimitReader(body, 64*1024)).Decode(&marker); err != nil {
+		log.Printf("Failed to read bulk marker %s: %v", key, err)
+		return bulkMarker{}, false
+	}
+	return marker, true
+}
+
+// writeBulkMarker records a finished source object. A marker that cannot be
+// written only means the object is downloaded again by the next run.
+func writeBulkMarker(ctx context.Context, key string, marker bulkMarker) {
+	data, err := json.Marshal(marker)
+	if err == nil {
+		err = repository.PutS3Object(ctx, key, data, "application/json")
+	}
+	if err != nil {
+		log.Printf("Failed to write bulk marker %s: %v", key, err)
+	}
+}
+
+// fail marks an object as failed with the code and message of err
+func (r BulkObjectResult) fail(err error) BulkObjectResult {
+	r.Status = BulkStatusFailed
+	r.Error = err.Error()
+	r.ErrorCode = ErrorCode(err)
+	return r
+}
+
+// variantsExist reports whether every variant of an image is already uploaded
+func variantsExist(destPrefix string, params KeyParams) (bool, error) {
+	for _, variant := range variantNames {
+		exists, err := repository.S3ObjectExists(variantObjectKey(destPrefix, params, variant))
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isImageKey reports whether an object key looks like a supported image
+func isImageKey(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	default:
+		return false
+	}
+}
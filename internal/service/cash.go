@@ -1,24 +1,294 @@
 package service
 
-import "sync"
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
-// Cache stores already processed image paths
-var Cache = struct {
-	sync.RWMutex
-	data map[string]map[string]string
-}{data: make(map[string]map[string]string)}
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/repository"
+	"github.com/abhinandpn/CompressImage/pkg/redis_client"
+)
+
+// Cache backends keep results for different destinations apart
+const (
+	CacheBackendLocal = "local"
+	CacheBackendS3    = "s3"
+)
+
+// ResultCache stores processed results so known images are not processed again
+type ResultCache interface {
+	Get(key string) (ImageResult, bool)
+	Set(key string, result ImageResult)
+	Stats() CacheStats
+}
+
+// CacheStats counts how the cache has been used
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"` // Removed to stay within the limits
+	Expired   uint64 `json:"expired"`   // Removed because their TTL passed
+	Stale     uint64 `json:"stale"`     // Removed because stored files were missing
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// lruEntry is a cached result and its bookkeeping
+type lruEntry struct {
+	key     string
+	result  ImageResult
+	size    int64
+	expires time.Time
+}
+
+// LRUCache is a bounded cache evicting the least recently used results first
+type LRUCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+	validate   bool
+	order      *list.List // Front is the most recently used entry
+	items      map[string]*list.Element
+	bytes      int64
+	stats      CacheStats
+}
+
+// NewLRUCache creates a cache holding at most maxEntries results and maxBytes
+// of data, each kept for ttl. With validate set, results whose stored files
+// have disappeared are dropped instead of being returned.
+func NewLRUCache(maxEntries int, maxBytes int64, ttl time.Duration, validate bool) *LRUCache {
+	return &LRUCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		validate:   validate,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Cache stores already processed image paths, keyed by cacheKey
+var Cache ResultCache = NewLRUCache(1000, 64*1024*1024, 24*time.Hour, true)
+
+// Cache backend names accepted by CACHE_BACKEND
+const (
+	CacheStoreMemory = "memory"
+	CacheStoreDisk   = "disk"
+	CacheStoreRedis  = "redis"
+)
+
+// ConfigureCache replaces the cache with the configured backend and limits.
+// It is meant to be called once at startup.
+func ConfigureCache() error {
+	switch backend := config.GetCacheBackend(); backend {
+	case CacheStoreMemory:
+		Cache = NewLRUCache(config.GetCacheMaxEntries(), config.GetCacheMaxBytes(), config.GetCacheTTL(), config.GetCacheValidate())
+	case CacheStoreDisk:
+		cache, err := OpenDiskCache(config.GetCacheFile(), config.GetCacheMaxEntries(), config.GetCacheMaxBytes(), config.GetCacheTTL(), config.GetCacheValidate())
+		if err != nil {
+			return err
+		}
+		Cache = cache
+	case CacheStoreRedis:
+		client := redis_client.NewClient(redis_client.Options{
+			Addr:     config.GetRedisAddr(),
+			Password: config.GetRedisPassword(),
+			DB:       config.GetRedisDB(),
+		})
+		if err := client.Ping(); err != nil {
+			return fmt.Errorf("failed to connect to redis at %s: %v", config.GetRedisAddr(), err)
+		}
+		Cache = NewRedisCache(client, config.GetRedisKeyPrefix(), config.GetCacheTTL(), config.GetCacheValidate())
+	default:
+		return fmt.Errorf("unknown cache backend %q", backend)
+	}
+	return nil
+}
+
+// Get returns the result stored under key if it is still valid
+func (c *LRUCache) Get(key string) (ImageResult, bool) {
+	c.mu.Lock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		c.mu.Unlock()
+		return ImageResult{}, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		c.stats.Expired++
+		c.stats.Misses++
+		c.mu.Unlock()
+		return ImageResult{}, false
+	}
+	c.order.MoveToFront(elem)
+	result := entry.result
+	c.mu.Unlock()
+
+	// Checking the store may be slow, so it happens outside the lock
+	if c.validate && !resultExists(result) {
+		c.mu.Lock()
+		if elem, ok := c.items[key]; ok && elem.Value.(*lruEntry) == entry {
+			c.remove(elem)
+			c.stats.Stale++
+		}
+		c.stats.Misses++
+		c.mu.Unlock()
+		return ImageResult{}, false
+	}
+
+	c.mu.Lock()
+	c.stats.Hits++
+	c.mu.Unlock()
+	result.CacheHit = true
+	return result, true
+}
+
+// Set stores a result, evicting older entries when the cache is full
+func (c *LRUCache) Set(key string, result ImageResult) {
+	c.set(key, result, time.Now().Add(c.ttl))
+}
+
+// set stores a result until expires and returns the keys of the older entries
+// evicted to make room for it
+func (c *LRUCache) set(key string, result ImageResult, expires time.Time) []string {
+	result.CacheHit = false
+	entry := &lruEntry{
+		key:     key,
+		result:  result,
+		size:    int64(len(key)) + result.size(),
+		expires: expires,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	// An entry larger than the whole cache is never stored
+	if c.maxBytes > 0 && entry.size > c.maxBytes {
+		return nil
+	}
+
+	c.items[key] = c.order.PushFront(entry)
+	c.bytes += entry.size
+
+	var evicted []string
+	for c.order.Len() > 0 && ((c.maxEntries > 0 && c.order.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		oldest := c.order.Back()
+		evicted = append(evicted, oldest.Value.(*lruEntry).key)
+		c.remove(oldest)
+		c.stats.Evictions++
+	}
+	return evicted
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *LRUCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// delete removes the entry stored under key and reports whether there was one
+func (c *LRUCache) delete(key string) bool {
+	return c.deleteIf(func(k string) bool { return k == key }) > 0
+}
+
+// deleteIf removes every entry whose key matches and returns how many were removed
+func (c *LRUCache) deleteIf(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, elem := range c.items {
+		if match(key) {
+			c.remove(elem)
+			removed++
+		}
+	}
+	return removed
+}
+
+// entries returns copies of the entries that have not expired, least
+// recently used first
+func (c *LRUCache) entries() []lruEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	entries := make([]lruEntry, 0, c.order.Len())
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		if entry := elem.Value.(*lruEntry); !now.After(entry.expires) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+// len returns the number of entries, including expired ones not removed yet
+func (c *LRUCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops an entry; the caller must hold the lock
+func (c *LRUCache) remove(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	c.order.Remove(elem)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+// resultExists reports whether every variant of a result is still in its store
+func resultExists(result ImageResult) bool {
+	if !result.Complete() || result.Source == nil || !storedObjectExists(result.Source.Path) {
+		return false
+	}
+	for _, variant := range result.Variants {
+		if !storedObjectExists(variant.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// storedObjectExists checks a local storage path or an S3 object URL
+func storedObjectExists(path string) bool {
+	if strings.HasPrefix(path, "https://") {
+		key, err := s3ObjectKey(path)
+		if err != nil {
+			return false
+		}
+		exists, err := repository.S3ObjectExists(key)
+		// Keep the entry when S3 cannot be reached, the objects are most likely fine
+		return exists || err != nil
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// cacheKey places a content key in the namespace of a backend and tenant, so
+// local paths are never returned for S3 uploads and tenants never share results
+func cacheKey(backend, tenant, contentKey string) string {
+	return backend + "/" + tenant + "/" + contentKey
+}
 
 // GetCachedResult returns the cached result if available
-func GetCachedResult(filename string) (map[string]string, bool) {
-	Cache.RLock()
-	defer Cache.RUnlock()
-	result, exists := Cache.data[filename]
-	return result, exists
+func GetCachedResult(key string) (ImageResult, bool) {
+	return Cache.Get(key)
 }
 
 // CacheResult saves processed image paths
-func CacheResult(filename string, paths map[string]string) {
-	Cache.Lock()
-	defer Cache.Unlock()
-	Cache.data[filename] = paths
+func CacheResult(key string, result ImageResult) {
+	Cache.Set(key, result)
 }
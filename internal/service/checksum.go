@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// UploadChecksum records the digests of an object sent to S3
+type UploadChecksum struct {
+	MD5    string `json:"md5"`    // Hex encoded, comparable with the ETag
+	SHA256 string `json:"sha256"` // Base64 encoded, as used by S3 checksums
+}
+
+// errChecksumMismatch marks an upload whose stored object does not match what was sent
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// computeChecksum hashes a file and rewinds it so it can be uploaded
+func computeChecksum(file io.ReadSeeker) (UploadChecksum, error) {
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), file); err != nil {
+		return UploadChecksum{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return UploadChecksum{}, err
+	}
+	return UploadChecksum{
+		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
+		SHA256: base64.StdEncoding.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
+
+// setChecksum asks S3 to verify the body against the checksum on arrival
+func (c UploadChecksum) setChecksum(input *s3.PutObjectInput) {
+	raw, _ := hex.DecodeString(c.MD5)
+	input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(raw))
+	input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	input.ChecksumSHA256 = aws.String(c.SHA256)
+}
+
+// verify compares what S3 reports it stored with the checksum that was sent.
+// The ETag is only an MD5 digest for objects that are not encrypted with KMS,
+// so KMS encrypted objects rely on the SHA-256 checksum alone.
+func (c UploadChecksum) verify(input *s3.PutObjectInput, out *s3.PutObjectOutput) error {
+	if out.ChecksumSHA256 != nil && aws.StringValue(out.ChecksumSHA256) != c.SHA256 {
+		return fmt.Errorf("%w: sha256 %s, expected %s", errChecksumMismatch, aws.StringValue(out.ChecksumSHA256), c.SHA256)
+	}
+
+	if isKMSEncryption(aws.StringValue(input.ServerSideEncryption)) {
+		return nil
+	}
+	etag := strings.Trim(aws.StringValue(out.ETag), `"`)
+	if etag != "" && etag != c.MD5 {
+		return fmt.Errorf("%w: etag %s, expected %s", errChecksumMismatch, etag, c.MD5)
+	}
+	return nil
+}
+
+// isKMSEncryption reports whether a server side encryption mode uses KMS, in
+// any of its variants such as aws:kms:dsse
+func isKMSEncryption(mode string) bool {
+	return strings.HasPrefix(mode, s3.ServerSideEncryptionAwsKms)
+}
+
+// isBadDigest reports whether S3 rejected an upload because the body was corrupted in transit
+func isBadDigest(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code() == "BadDigest" || aerr.Code() == "XAmzContentChecksumMismatch"
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestComputeChecksum(t *testing.T) {
+	tests := []struct {
+		data   string
+		md5    string
+		sha256 string
+	}{
+		{"", "d41d8cd98f00b204e9800998ecf8427e", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{"hello world", "5eb63bbbe01eeed093cb22bb8f5acdc3", "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			got, err := computeChecksum(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.MD5 != tt.md5 || got.SHA256 != tt.sha256 {
+				t.Errorf("computeChecksum(%q) = %+v, want md5 %s and sha256 %s", tt.data, got, tt.md5, tt.sha256)
+			}
+		})
+	}
+}
+
+func TestUploadChecksumVerify(t *testing.T) {
+	sum := UploadChecksum{MD5: "5eb63bbbe01eeed093cb22bb8f5acdc3", SHA256: "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="}
+	tests := []struct {
+		name       string
+		encryption string
+		etag       string
+		sha256     string
+		mismatch   bool
+	}{
+		{"matching", "", `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, sum.SHA256, false},
+		{"nothing reported", "", "", "", false},
+		{"other etag", "", `"d41d8cd98f00b204e9800998ecf8427e"`, sum.SHA256, true},
+		{"other sha256", "", `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", true},
+		{"kms etag", "aws:kms", `"d41d8cd98f00b204e9800998ecf8427e"`, sum.SHA256, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &s3.PutObjectInput{}
+			if tt.encryption != "" {
+				input.ServerSideEncryption = aws.String(tt.encryption)
+			}
+			out := &s3.PutObjectOutput{}
+			if tt.etag != "" {
+				out.ETag = aws.String(tt.etag)
+			}
+			if tt.sha256 != "" {
+				out.ChecksumSHA256 = aws.String(tt.sha256)
+			}
+			err := sum.verify(input, out)
+			if got := errors.Is(err, errChecksumMismatch); got != tt.mismatch || (err != nil && !got) {
+				t.Errorf("verify() = %v, want mismatch %v", err, tt.mismatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compactMinRecords keeps small cache files from being rewritten all the time
+const compactMinRecords = 1000
+
+// diskRecord is one line of the cache file. Later records for a key replace
+// earlier ones, and a delete record removes the key.
+type diskRecord struct {
+	Op      string       `json:"op"` // "set" or "del"
+	Key     string       `json:"key"`
+	Expires time.Time    `json:"expires,omitempty"`
+	Result  *ImageResult `json:"result,omitempty"`
+}
+
+// DiskCacheEntry is a live entry of the disk cache
+type DiskCacheEntry struct {
+	Key     string      `json:"key"`
+	Expires time.Time   `json:"expires"`
+	Result  ImageResult `json:"result"`
+}
+
+// DiskCache is an LRUCache persisted to an append-only file so it survives
+// restarts. Every stored result, eviction and explicit removal is logged to
+// the file, which is replayed on open and compacted once most of its records
+// are outdated. Expired and stale entries are not logged, replaying the file
+// drops them again.
+type DiskCache struct {
+	mu      sync.Mutex // Guards the file; the LRUCache has its own lock
+	lru     *LRUCache
+	path    string
+	file    *os.File
+	unlock  func() error
+	records int // Records in the file, live or not
+}
+
+// OpenDiskCache opens or creates the cache file at path, holding at most
+// maxEntries results and maxBytes of data. A limit of 0 means no limit. Only
+// one process can have the file open at a time.
+func OpenDiskCache(path string, maxEntries int, maxBytes int64, ttl time.Duration, validate bool) (*DiskCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache file: %v", err)
+	}
+	unlock, err := lockFile(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cache file %s is in use: %v", path, err)
+	}
+
+	c := &DiskCache{
+		lru:    NewLRUCache(0, 0, ttl, validate),
+		path:   path,
+		file:   file,
+		unlock: unlock,
+	}
+	damaged, err := c.load()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	// The file is replayed without limits, as it already records the
+	// evictions; only limits tighter than before evict entries here
+	replayed := c.lru
+	c.lru = NewLRUCache(maxEntries, maxBytes, ttl, validate)
+	for _, entry := range replayed.entries() {
+		c.lru.set(entry.key, entry.result, entry.expires)
+	}
+
+	// Rewrite a damaged file right away, so new records do not follow a
+	// partial line. The same goes for entries evicted by tighter limits.
+	if damaged || c.lru.Stats().Evictions > 0 {
+		err = c.compactLocked(nil)
+	} else {
+		c.maybeCompactLocked()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// load replays the cache file into the LRUCache and reports whether any
+// record could not be read. Later records count as more recently used.
+func (c *DiskCache) load() (bool, error) {
+	if _, err := c.file.Seek(0, 0); err != nil {
+		return false, err
+	}
+
+	scanner := bufio.NewScanner(c.file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	now := time.Now()
+	damaged := false
+	for scanner.Scan() {
+		var record diskRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A crash can leave a partial last line behind
+			damaged = true
+			continue
+		}
+		c.records++
+
+		switch record.Op {
+		case "set":
+			if record.Result == nil || now.After(record.Expires) {
+				c.lru.delete(record.Key)
+				continue
+			}
+			c.lru.set(record.Key, *record.Result, record.Expires)
+		case "del":
+			c.lru.delete(record.Key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read cache file: %v", err)
+	}
+	return damaged, nil
+}
+
+// Get returns the result stored under key if it is still valid
+func (c *DiskCache) Get(key string) (ImageResult, bool) {
+	return c.lru.Get(key)
+}
+
+// Set stores a result and appends it to the cache file, evicting older
+// entries when the cache is full
+func (c *DiskCache) Set(key string, result ImageResult) {
+	result.CacheHit = false
+	expires := time.Now().Add(c.lru.ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// An entry larger than the whole cache is never stored
+	if c.lru.maxBytes > 0 && int64(len(key))+result.size() > c.lru.maxBytes {
+		if c.lru.delete(key) {
+			c.appendDeleteLocked(key)
+		}
+		return
+	}
+
+	if err := c.append(diskRecord{Op: "set", Key: key, Expires: expires, Result: &result}); err != nil {
+		c.logError("write", err)
+		return
+	}
+	for _, evicted := range c.lru.set(key, result, expires) {
+		if c.appendDeleteLocked(evicted) != nil {
+			break
+		}
+	}
+	c.maybeCompactLocked()
+}
+
+// Stats returns a snapshot of the cache counters; Bytes is the file size
+func (c *DiskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.lru.Stats()
+	if info, err := c.file.Stat(); err == nil {
+		stats.Bytes = info.Size()
+	}
+	return stats
+}
+
+// Entries returns the live entries sorted by key
+func (c *DiskCache) Entries() []DiskCacheEntry {
+	live := c.lru.entries()
+	entries := make([]DiskCacheEntry, 0, len(live))
+	for _, entry := range live {
+		entries = append(entries, DiskCacheEntry{Key: entry.key, Expires: entry.expires, Result: entry.result})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// Delete removes a single entry, reporting whether it existed
+func (c *DiskCache) Delete(key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.lru.delete(key) {
+		return false, nil
+	}
+	return true, c.appendDeleteLocked(key)
+}
+
+// Purge removes every entry whose key starts with prefix and returns how many
+// were removed. An empty prefix removes everything. The entries stay in
+// memory until the file without them is in place, so a failed purge changes
+// nothing.
+func (c *DiskCache) Purge(prefix string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	purged := func(key string) bool { return strings.HasPrefix(key, prefix) }
+	if err := c.compactLocked(purged); err != nil {
+		return 0, err
+	}
+	return c.lru.deleteIf(purged), nil
+}
+
+// Compact rewrites the cache file with only the live entries
+func (c *DiskCache) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compactLocked(nil)
+}
+
+// Close releases the cache file
+func (c *DiskCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unlock != nil {
+		c.unlock()
+	}
+	return c.file.Close()
+}
+
+// appendDeleteLocked records the removal of an entry; the caller must hold the lock
+func (c *DiskCache) appendDeleteLocked(key string) error {
+	if err := c.append(diskRecord{Op: "del", Key: key}); err != nil {
+		c.logError("write", err)
+		return err
+	}
+	c.maybeCompactLocked()
+	return nil
+}
+
+// append writes a single record to the end of the cache file
+func (c *DiskCache) append(record diskRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	c.records++
+	return nil
+}
+
+// maybeCompactLocked compacts once outdated records outnumber live ones
+func (c *DiskCache) maybeCompactLocked() {
+	if c.records < compactMinRecords || c.records < 2*c.lru.len() {
+		return
+	}
+	if err := c.compactLocked(nil); err != nil {
+		c.logError("compact", err)
+	}
+}
+
+// compactLocked replaces the cache file with one holding only live entries,
+// leaving out those skip reports, if it is not nil. The new file is written
+// next to the old one and renamed over it, so a crash never leaves a half
+// written cache behind. Entries are written least recently used first, so
+// loading the file restores their order.
+func (c *DiskCache) compactLocked(skip func(key string) bool) error {
+	tmpPath := c.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted cache file: %v", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	records := 0
+	for _, entry := range c.lru.entries() {
+		if skip != nil && skip(entry.key) {
+			continue
+		}
+		result := entry.result
+		line, err := json.Marshal(diskRecord{Op: "set", Key: entry.key, Expires: entry.expires, Result: &result})
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		writer.Write(append(line, '\n'))
+		records++
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write compacted cache file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write compacted cache file: %v", err)
+	}
+
+	// Lock the new file before it replaces the old one
+	unlock, err := lockFile(tmp)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to lock compacted cache file: %v", err)
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		unlock()
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace cache file: %v", err)
+	}
+
+	c.unlock()
+	c.file.Close()
+	c.file = tmp
+	c.unlock = unlock
+	c.records = records
+	return nil
+}
+
+// logError reports a cache file problem; the cache keeps working from memory
+func (c *DiskCache) logError(action string, err error) {
+	log.Printf("Disk cache failed to %s %s: %v", action, c.path, err)
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// diskResult is a result whose single variant is stored at path. With a
+// one-letter key and path it counts 50 bytes against the byte limit.
+func diskResult(path string) ImageResult {
+	return ImageResult{Variants: []VariantResult{{Name: "small", Path: path, Format: "jpg"}}}
+}
+
+// openDiskCache opens a cache that does not check the stored files
+func openDiskCache(t *testing.T, path string, maxEntries int, maxBytes int64) *DiskCache {
+	t.Helper()
+	cache, err := OpenDiskCache(path, maxEntries, maxBytes, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+// cacheLines returns the records in the cache file, failing on any that
+// cannot be read
+func cacheLines(t *testing.T, path string) []diskRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []diskRecord
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var record diskRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("cache file holds a broken record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// entryKeys lists the keys of the live entries
+func entryKeys(cache *DiskCache) string {
+	var keys []string
+	for _, entry := range cache.Entries() {
+		keys = append(keys, entry.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestDiskCacheReplaysAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	cache := openDiskCache(t, path, 0, 0)
+	cache.Set("a", diskResult("1"))
+	cache.Set("b", diskResult("2"))
+	cache.Set("a", diskResult("3"))
+	cache.Set("c", diskResult("4"))
+	if ok, err := cache.Delete("c"); !ok || err != nil {
+		t.Fatalf("Delete(c) = %v, %v, want true", ok, err)
+	}
+	cache.Close()
+
+	cache = openDiskCache(t, path, 0, 0)
+	if got := entryKeys(cache); got != "a,b" {
+		t.Errorf("entries after reopening are %q, want a,b", got)
+	}
+	if result, ok := cache.Get("a"); !ok || result.Variants[0].Path != "3" {
+		t.Errorf("Get(a) = %+v, %v, want the result stored last", result, ok)
+	}
+	if _, ok := cache.Get("c"); ok {
+		t.Error("deleted entry is back after reopening")
+	}
+}
+
+func TestDiskCacheSkipsDamagedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	expires := time.Now().Add(time.Hour)
+	var data []byte
+	for _, key := range []string{"a", "b"} {
+		result := diskResult(key)
+		line, err := json.Marshal(diskRecord{Op: "set", Key: key, Expires: expires, Result: &result})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+		if key == "a" {
+			data = append(data, "not a record\n"...)
+		}
+	}
+	// A crash while writing leaves the last line unfinished
+	data = append(data, `{"op":"set","key":"c","expires":`...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := openDiskCache(t, path, 0, 0)
+	if got := entryKeys(cache); got != "a,b" {
+		t.Errorf("entries are %q, want a,b", got)
+	}
+
+	// The damaged file is rewritten, so new records start on a line of their own
+	cache.Set("d", diskResult("d"))
+	if records := cacheLines(t, path); len(records) != 3 {
+		t.Errorf("cache file holds %d records, want 3", len(records))
+	}
+}
+
+func TestDiskCacheCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	cache := openDiskCache(t, path, 0, 0)
+	cache.Set("a", diskResult("1"))
+	for i := 0; i < compactMinRecords+10; i++ {
+		cache.Set("b", diskResult("2"))
+	}
+
+	// Outdated records of b are dropped once there are enough of them
+	if records := cacheLines(t, path); len(records) >= compactMinRecords {
+		t.Errorf("cache file holds %d records, want it compacted", len(records))
+	}
+	if err := cache.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	records := cacheLines(t, path)
+	if len(records) != 2 || records[0].Key != "a" || records[1].Key != "b" {
+		t.Errorf("compacted file holds %+v, want a and b, least recently used first", records)
+	}
+
+	if removed, err := cache.Purge("a"); removed != 1 || err != nil {
+		t.Errorf("Purge(a) = %d, %v, want 1", removed, err)
+	}
+	cache.Close()
+
+	cache = openDiskCache(t, path, 0, 0)
+	if got := entryKeys(cache); got != "b" {
+		t.Errorf("entries after reopening are %q, want b", got)
+	}
+}
+
+func TestDiskCacheEvicts(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int64
+	}{
+		{"entries", 2, 0},
+		{"bytes", 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.log")
+			cache := openDiskCache(t, path, tt.maxEntries, tt.maxBytes)
+			cache.Set("a", diskResult("1"))
+			cache.Set("b", diskResult("2"))
+			cache.Get("a")
+			cache.Set("c", diskResult("3"))
+
+			if got := entryKeys(cache); got != "a,c" {
+				t.Errorf("entries are %q, want the least recently used one evicted", got)
+			}
+			if stats := cache.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+				t.Errorf("stats are %+v, want 1 eviction and 2 entries", stats)
+			}
+			cache.Close()
+
+			// The eviction is in the file, so b does not come back
+			cache = openDiskCache(t, path, tt.maxEntries, tt.maxBytes)
+			if got := entryKeys(cache); got != "a,c" {
+				t.Errorf("entries after reopening are %q, want a,c", got)
+			}
+		})
+	}
+}
+
+func TestDiskCacheAppliesTighterLimitsOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	cache := openDiskCache(t, path, 0, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, diskResult(key))
+	}
+	cache.Close()
+
+	cache = openDiskCache(t, path, 1, 0)
+	if got := entryKeys(cache); got != "c" {
+		t.Errorf("entries are %q, want only the most recent one", got)
+	}
+	if records := cacheLines(t, path); len(records) != 1 {
+		t.Errorf("cache file holds %d records, want it compacted to 1", len(records))
+	}
+}
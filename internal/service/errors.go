@@ -0,0 +1,54 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Stable error codes reported to clients
+const (
+	ErrCodeInvalidRequest     = "invalid_request"
+	ErrCodeNoFiles            = "no_files"
+	ErrCodeUnsupportedFormat  = "unsupported_format"
+	ErrCodeTooLarge           = "too_large"
+	ErrCodePixelLimit         = "pixel_limit"
+	ErrCodeUnknownProfile     = "unknown_profile"
+	ErrCodeProcessingFailed   = "processing_failed"
+	ErrCodeStorageUnavailable = "storage_unavailable"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeMethodNotAllowed   = "method_not_allowed"
+	ErrCodeQueueFull          = "queue_full"
+	ErrCodeFetchFailed        = "fetch_failed" // A remote image could not be downloaded
+	ErrCodeInternal           = "internal_error"
+)
+
+// Error is a failure with a stable code clients can rely on. Message is safe
+// to show to clients, while Err keeps the underlying cause for the logs.
+type Error struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+// NewError creates an Error with the given code and client facing message
+func NewError(code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// ErrorCode returns the code of err, or ErrCodeInternal when it has none
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ErrCodeInternal
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package service
+
+import "os"
+
+// lockFile is a no-op where advisory file locks are not available
+func lockFile(file *os.File) (func() error, error) {
+	return func() error { return nil }, nil
+}
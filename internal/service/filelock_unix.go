@@ -0,0 +1,18 @@
+//go:build unix
+
+package service
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on file so a second process cannot use it
+func lockFile(file *os.File) (func() error, error) {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	}, nil
+}
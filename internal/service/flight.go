@@ -0,0 +1,58 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+)
+
+// flightCall is a processing job that other requests can wait on
+type flightCall struct {
+	done   chan struct{}
+	result ImageResult
+	err    error
+}
+
+// flightGroup makes sure each key is processed by only one request at a time.
+// Requests for a key that is already being processed wait for that job and
+// share its result instead of doing the same work again.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flights coalesces identical processing requests, keyed like the cache
+var flights = &flightGroup{calls: make(map[string]*flightCall)}
+
+// Do runs fn for key unless a call for key is in flight, in which case it waits
+// for that call. shared reports whether the result came from another request.
+// If fn panics, the waiting requests get an error and the panic goes on in the
+// request that ran fn.
+func (g *flightGroup) Do(key string, fn func() (ImageResult, error)) (result ImageResult, err error, shared bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.result, call.err, true
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			call.result = ImageResult{}
+			call.err = NewError(ErrCodeInternal, "Processing failed", fmt.Errorf("panic: %v", recovered))
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+		if recovered != nil {
+			panic(recovered)
+		}
+	}()
+
+	call.result, call.err = fn()
+	return call.result, call.err, false
+}
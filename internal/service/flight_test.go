@@ -0,0 +1,143 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlightGroup returns an empty group
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// waitForFlight blocks until a call for key is in flight
+func waitForFlight(t *testing.T, g *flightGroup, key string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		_, ok := g.calls[key]
+		g.mu.Unlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatalf("no call for %s started", key)
+}
+
+func TestFlightGroupShares(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fn := func() (ImageResult, error) {
+		calls.Add(1)
+		<-release
+		return ImageResult{ID: "done"}, nil
+	}
+
+	const waiters = 5
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	results := make(chan ImageResult, waiters+1)
+	run := func() {
+		defer wg.Done()
+		result, err, shared := g.Do("key", fn)
+		if err != nil {
+			t.Error(err)
+		}
+		if shared {
+			sharedCount.Add(1)
+		}
+		results <- result
+	}
+
+	wg.Add(1)
+	go run()
+	waitForFlight(t, g, "key")
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go run()
+	}
+	// Give the waiters time to join the call in flight
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fn ran %d times, want once", n)
+	}
+	if n := sharedCount.Load(); n != waiters {
+		t.Errorf("%d requests shared the result, want %d", n, waiters)
+	}
+	for result := range results {
+		if result.ID != "done" {
+			t.Errorf("got result %+v, want the one of the call in flight", result)
+		}
+	}
+}
+
+func TestFlightGroupForgetsFinishedCalls(t *testing.T) {
+	g := newFlightGroup()
+	calls := 0
+	fn := func() (ImageResult, error) {
+		calls++
+		return ImageResult{}, errors.New("failed")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err, shared := g.Do("key", fn); err == nil || shared {
+			t.Errorf("call %d: got err %v, shared %v, want its own error", i, err, shared)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("fn ran %d times, want a new call once the first finished", calls)
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("%d calls are still in flight", len(g.calls))
+	}
+}
+
+func TestFlightGroupPanics(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+
+	panicked := make(chan any, 1)
+	go func() {
+		defer func() { panicked <- recover() }()
+		g.Do("key", func() (ImageResult, error) {
+			<-release
+			panic("boom")
+		})
+	}()
+	waitForFlight(t, g, "key")
+
+	waited := make(chan error, 1)
+	go func() {
+		_, err, shared := g.Do("key", func() (ImageResult, error) {
+			t.Error("waiter ran its own call")
+			return ImageResult{}, nil
+		})
+		if !shared {
+			t.Error("waiter did not share the call in flight")
+		}
+		waited <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if got := <-panicked; got != "boom" {
+		t.Errorf("caller recovered %v, want the panic to go on", got)
+	}
+	var serviceErr *Error
+	if err := <-waited; !errors.As(err, &serviceErr) || serviceErr.Code != ErrCodeInternal {
+		t.Errorf("waiter got error %v, want an internal error", err)
+	}
+
+	// The key is free again after the panic
+	if _, err, shared := g.Do("key", func() (ImageResult, error) { return ImageResult{}, nil }); err != nil || shared {
+		t.Errorf("call after the panic got err %v, shared %v, want a new call", err, shared)
+	}
+}
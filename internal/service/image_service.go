@@ -1,234 +1,505 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"image"
+	"io"
 	"log"
 	"mime/multipart"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/repository"
 	"github.com/abhinandpn/CompressImage/server"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-// ProcessAndCompressImage handles image processing via Imaginary API (concurrent)
-func ProcessAndCompressImage(filename string, imageData []byte, size int64, originalWidth, originalHeight int) (map[string]string, error) {
+// ProcessAndCompressImage handles image processing via Imaginary API (concurrent).
+// Variants that fail are reported in the result; only complete results are cached.
+func ProcessAndCompressImage(opts UploadOptions, filename string, imageData []byte, size int64, originalWidth, originalHeight int) (ImageResult, error) {
 	baseName := strings.TrimSuffix(filename, filepath.Ext(filename))
 	baseName = strings.ReplaceAll(baseName, " ", "_")
 
-	if cachedPaths, exists := GetCachedResult(baseName); exists {
-		return cachedPaths, nil
-	}
+	params := NewKeyParams(opts.Tenant, baseName, imageData)
+	specs := variantSpecs(size, originalWidth, originalHeight)
+	key := cacheKey(CacheBackendLocal, opts.Tenant, contentCacheKey(params.Hash, specs))
 
-	var wg sync.WaitGroup
-	resultChan := make(chan struct {
-		key  string
-		path string
-		err  error
-	}, 4)
+	// Identical uploads arriving together are processed once and share the result
+	processed := false
+	result, err, _ := flights.Do(key, func() (ImageResult, error) {
+		// Results cached before uploads were kept lack the uploaded file
+		if cached, exists := GetCachedResult(key); exists && cached.Source != nil {
+			return cached, nil
+		}
 
-	// Define compression quality levels and target sizes
-	sizes := map[string]int{
-		"original":  determineOriginalSizeReduction(size), // Original size with potential reduction
-		"250-300KB": 80,                                   // Adjust quality for 250-300KB
-		"150-200KB": 60,                                   // Adjust quality for 150-200KB
-		"10-50KB":   20,                                   // Adjust quality for 10-50KB
+		processed = true
+		result := ImageResult{Variants: processLocalVariants(params, imageData, specs, opts.OnVariant), StoredAt: params.Time}
+		source, err := storeLocalSource(params, imageData)
+		if err != nil {
+			log.Printf("Failed to store uploaded file %s: %v", filename, err)
+		} else {
+			result.Source = &source
+		}
+		if result.Complete() && result.Source != nil {
+			CacheResult(key, result)
+		}
+		return result, nil
+	})
+	// Cached and shared results were not reported while they were produced.
+	// They may be stored under the name of another upload of the same image.
+	if err == nil && !processed {
+		result = placeLocalResult(params, result)
+		opts.notify(result.Variants...)
 	}
+	if err == nil {
+		recordImage(&result, imageID(key, variantKey(params, "")), CacheBackendLocal, opts.Tenant, filename)
+	}
+	return result, err
+}
 
-	aspectRatio := float64(originalWidth) / float64(originalHeight)
+// processLocalVariants encodes every variant of an image into the storage folder.
+// onVariant, if not nil, is called as each variant finishes.
+func processLocalVariants(params KeyParams, imageData []byte, specs []variantSpec, onVariant func(VariantResult)) []VariantResult {
+	var wg sync.WaitGroup
+	results := make([]VariantResult, len(specs))
 
-	for key, quality := range sizes {
+	for i, spec := range specs {
 		wg.Add(1)
-		go func(k string, q int) {
+		go func(i int, spec variantSpec) {
 			defer wg.Done()
-
-			// Calculate dimensions based on aspect ratio
-			var newWidth, newHeight int
-			if k == "original" {
-				newWidth, newHeight = originalWidth, originalHeight // Keep original dimensions
-			} else {
-				// Scale dimensions to maintain aspect ratio (start with a reasonable base width)
-				baseWidth := 1200 // Starting point, adjust if needed
-				newWidth = baseWidth
-				newHeight = int(float64(newWidth) / aspectRatio)
-			}
+			started := time.Now()
+			result := newVariantResult(spec)
 
 			// Process the image with consistent dimensions
-			path, err := server.ProcessImageWithImaginary(imageData, q, baseName+"_"+k, newWidth, newHeight)
-			resultChan <- struct {
-				key  string
-				path string
-				err  error
-			}{key: k, path: path, err: err}
-		}(key, quality)
-	}
-
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	imagePaths := make(map[string]string)
-	for res := range resultChan {
-		if res.err == nil {
-			imagePaths[res.key] = res.path
-		}
+			path, err := server.ProcessImageWithImaginary(imageData, spec.Quality, variantKey(params, spec.Name), spec.Width, spec.Height)
+			if err == nil {
+				result.Path = path
+				result.Bytes, err = fileSize(path)
+			}
+			if err != nil {
+				err = NewError(ErrCodeProcessingFailed, "Failed to process image", err)
+			}
+			results[i] = result.finish(started, err)
+			if onVariant != nil {
+				onVariant(results[i])
+			}
+		}(i, spec)
 	}
 
-	CacheResult(baseName, imagePaths)
-	return imagePaths, nil
+	wg.Wait()
+	return results
 }
 
-// S3ProcessAndCompressImage handles image processing and uploads to S3
-func S3ProcessAndCompressImage(filename string, imageData []byte, size int64, originalWidth, originalHeight int) (map[string]string, error) {
+// S3ProcessAndCompressImage handles image processing and uploads to S3.
+// It returns the URL and upload checksum of every variant. Cancelling ctx
+// aborts uploads that are still in progress. Variants that fail are reported
+// in the result; only complete results are cached.
+func S3ProcessAndCompressImage(ctx context.Context, opts UploadOptions, filename string, imageData []byte, size int64, originalWidth, originalHeight int) (ImageResult, error) {
 	baseName := strings.TrimSuffix(filename, filepath.Ext(filename))
 	baseName = strings.ReplaceAll(baseName, " ", "_")
 
-	// Check if the image is cached
-	if cachedPaths, exists := GetCachedResult(baseName); exists {
-		return cachedPaths, nil
+	params := NewKeyParams(opts.Tenant, baseName, imageData)
+	specs := variantSpecs(size, originalWidth, originalHeight)
+	destPrefix := config.GetS3DestinationPrefix()
+
+	profile, ok := GetS3Profile(opts.Profile)
+	if !ok {
+		return ImageResult{}, NewError(ErrCodeUnknownProfile, fmt.Sprintf("Unknown upload profile %q", opts.Profile), nil)
 	}
 
-	var wg sync.WaitGroup
-	resultChan := make(chan struct {
-		key  string
-		path string
-		err  error
-	}, 4)
+	// S3 results also depend on the bucket and prefix they were written to
+	// and on the object settings of the profile they were uploaded with
+	backend := CacheBackendS3 + ":" + config.GetAWSBucketName() + "/" + destPrefix + "@" + profile.fingerprint()
+	key := cacheKey(backend, opts.Tenant, contentCacheKey(params.Hash, specs))
+
+	for {
+		// Identical uploads arriving together are processed once and share the result
+		processed := false
+		result, err, shared := flights.Do(key, func() (ImageResult, error) {
+			// Check if the image is cached; results cached before uploads
+			// were kept lack the uploaded file
+			if cached, exists := GetCachedResult(key); exists && cached.Source != nil {
+				return cached, nil
+			}
+
+			processed = true
+			result := ImageResult{Variants: s3ProcessVariants(ctx, params, profile, imageData, specs, originalWidth, originalHeight, destPrefix, opts.OnVariant), StoredAt: params.Time}
+			source, err := s3StoreSource(ctx, params, profile, imageData, originalWidth, originalHeight, destPrefix)
+			if err := ctx.Err(); err != nil {
+				return ImageResult{}, err
+			}
+			if err != nil {
+				log.Printf("Failed to keep uploaded file %s in S3: %v", filename, err)
+			} else {
+				result.Source = &source
+			}
+
+			// Cache the results
+			if result.Complete() && result.Source != nil {
+				CacheResult(key, result)
+			}
+			return result, nil
+		})
 
-	// Define compression quality levels and target sizes
-	sizes := map[string]int{
-		"original":  determineOriginalSizeReduction(size), // Original size with potential reduction
-		"250-300KB": 80,                                   // Adjust quality for 250-300KB
-		"150-200KB": 60,                                   // Adjust quality for 150-200KB
-		"10-50KB":   20,                                   // Adjust quality for 10-50KB
+		// The request doing the work went away, so a waiting request takes over
+		leaderGone := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+		if shared && leaderGone && ctx.Err() == nil {
+			continue
+		}
+		// Cached and shared results were not reported while they were produced.
+		// They may be stored under the name of another upload of the same image.
+		if err == nil && !processed {
+			result = placeS3Result(ctx, params, profile, result, destPrefix)
+			opts.notify(result.Variants...)
+		}
+		if err == nil {
+			recordImage(&result, imageID(key, variantKey(params, "")), CacheBackendS3, opts.Tenant, filename)
+		}
+		return result, err
 	}
+}
 
-	// Calculate aspect ratio from original dimensions
-	aspectRatio := float64(originalWidth) / float64(originalHeight)
+// s3ProcessVariants encodes every variant of an image and uploads them under destPrefix
+// using the same relative keys as local storage. onVariant, if not nil, is
+// called as each variant finishes.
+func s3ProcessVariants(ctx context.Context, params KeyParams, profile S3Profile, imageData []byte, specs []variantSpec, originalWidth, originalHeight int, destPrefix string, onVariant func(VariantResult)) []VariantResult {
+	var wg sync.WaitGroup
+	results := make([]VariantResult, len(specs))
 
 	// Process the image in different sizes concurrently
-	for key, quality := range sizes {
+	for i, spec := range specs {
 		wg.Add(1)
-		go func(k string, q int) {
+		go func(i int, spec variantSpec) {
 			defer wg.Done()
-
-			// Calculate dimensions based on aspect ratio
-			var newWidth, newHeight int
-			if k == "original" {
-				newWidth, newHeight = originalWidth, originalHeight // Keep original dimensions
-			} else {
-				// Scale dimensions to maintain aspect ratio (start with a reasonable base width)
-				baseWidth := 1200 // Starting point, adjust if needed
-				newWidth = baseWidth
-				newHeight = int(float64(newWidth) / aspectRatio)
+			if onVariant != nil {
+				defer func() { onVariant(results[i]) }()
 			}
+			started := time.Now()
+			result := newVariantResult(spec)
 
 			// Process the image with consistent dimensions
-			path, err := server.ProcessImageWithImaginary(imageData, q, baseName+"_"+k, newWidth, newHeight)
+			path, err := server.ProcessImageWithImaginary(imageData, spec.Quality, variantKey(params, spec.Name), spec.Width, spec.Height)
 			if err != nil {
-				resultChan <- struct {
-					key  string
-					path string
-					err  error
-				}{key: k, path: "", err: fmt.Errorf("failed to process image: %v", err)}
+				results[i] = result.finish(started, NewError(ErrCodeProcessingFailed, "Failed to process image", err))
 				return
 			}
 
 			// Open the processed image file
 			file, err := os.Open(path)
 			if err != nil {
-				resultChan <- struct {
-					key  string
-					path string
-					err  error
-				}{key: k, path: "", err: fmt.Errorf("failed to open file: %v", err)}
+				results[i] = result.finish(started, NewError(ErrCodeProcessingFailed, "Failed to open file", err))
 				return
 			}
 			defer file.Close()
+			// Another request may replace the file at path; the open file stays complete
+			stat, err := file.Stat()
+			if err == nil {
+				result.Bytes = stat.Size()
+			}
+			if err != nil {
+				results[i] = result.finish(started, NewError(ErrCodeProcessingFailed, "Failed to read file", err))
+				return
+			}
 
 			// Upload the image to S3
-			s3URL, uploadErr := S3Imageupload(file, baseName+"_"+k+".jpg", "image/jpeg")
-			if uploadErr == nil {
-				resultChan <- struct {
-					key  string
-					path string
-					err  error
-				}{key: k, path: s3URL, err: nil}
-			} else {
-				resultChan <- struct {
-					key  string
-					path string
-					err  error
-				}{key: k, path: "", err: uploadErr}
+			info := variantObjectInfo{
+				Variant:        spec.Name,
+				Tenant:         params.Tenant,
+				SourceHash:     params.Hash,
+				OriginalWidth:  originalWidth,
+				OriginalHeight: originalHeight,
 			}
-		}(key, quality)
+			s3URL, checksum, err := uploadToS3(ctx, file, variantObjectKey(destPrefix, params, spec.Name), "image/jpeg", func(input *s3.PutObjectInput) {
+				profile.apply(input, info)
+			})
+			if err != nil {
+				err = NewError(ErrCodeStorageUnavailable, "Failed to upload image to S3", err)
+			}
+			result.Path = s3URL
+			result.Checksum = &checksum
+			results[i] = result.finish(started, err)
+		}(i, spec)
 	}
 
 	// Wait for all goroutines to finish
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+	wg.Wait()
+	return results
+}
 
-	// Collect the results from each goroutine
-	imagePaths := make(map[string]string)
-	for res := range resultChan {
-		if res.err == nil {
-			imagePaths[res.key] = res.path
+// placeResult moves a result produced for another upload of the same image to
+// the keys of this upload, so identical images are encoded once while every
+// upload still finds its files under its own name and folder. The keys keep
+// the date the image was first stored, so the same name maps to the same keys
+// on every instance sharing the cache. place copies the file at path to key,
+// unless it is already there, and returns where the copy is. Variants that
+// cannot be copied are reported as failed.
+func placeResult(params KeyParams, result ImageResult, place func(path, key string) (string, error)) ImageResult {
+	if !result.StoredAt.IsZero() {
+		params.Time = result.StoredAt
+	}
+	placed := result
+	placed.Variants = make([]VariantResult, len(result.Variants))
+	for i, v := range result.Variants {
+		if v.Error == "" {
+			started := time.Now()
+			path, err := place(v.Path, variantKey(params, v.Name))
+			if err == nil {
+				v.Path = path
+			} else {
+				v = v.finish(started, err)
+			}
 		}
+		placed.Variants[i] = v
 	}
 
-	// Cache the results
-	CacheResult(baseName, imagePaths)
-	return imagePaths, nil
+	if result.Source != nil {
+		source := *result.Source
+		placed.Source = nil
+		ext := strings.TrimPrefix(filepath.Ext(source.Path), ".")
+		if path, err := place(source.Path, sourceKey(params, ext)); err != nil {
+			log.Printf("Failed to copy uploaded file to %s: %v", params.BaseName, err)
+		} else {
+			source.Path = path
+			placed.Source = &source
+		}
+	}
+	return placed
 }
 
-// Determines the original image compression based on file size
-func determineOriginalSizeReduction(size int64) int {
-	switch {
-	case size > 5*1024*1024: // If size > 5MB
-		return 50
-	case size > 2*1024*1024: // If size is between 2MB and 5MB
-		return 70
-	default: // If size is < 2MB
-		return 100 // No compression
+// placeLocalResult copies the files of a result to the keys of this upload
+func placeLocalResult(params KeyParams, result ImageResult) ImageResult {
+	return placeResult(params, result, func(path, key string) (string, error) {
+		target := filepath.Join("storage", key)
+		if path == target {
+			return target, nil
+		}
+		if err := copyLocalFile(path, target); err != nil {
+			return "", NewError(ErrCodeProcessingFailed, "Failed to copy image", err)
+		}
+		return target, nil
+	})
+}
+
+// placeS3Result copies the objects of a result to the keys of this upload
+// within the bucket, without downloading them
+func placeS3Result(ctx context.Context, params KeyParams, profile S3Profile, result ImageResult, destPrefix string) ImageResult {
+	bucket := config.GetAWSBucketName()
+	return placeResult(params, result, func(path, key string) (string, error) {
+		target := s3ObjectURL(bucket, destPrefix+key)
+		if path == target {
+			return target, nil
+		}
+		source, err := s3ObjectKey(path)
+		if err == nil {
+			err = repository.CopyS3Object(ctx, source, destPrefix+key, profile.applyCopy)
+		}
+		if err != nil {
+			return "", NewError(ErrCodeStorageUnavailable, "Failed to copy image in S3", err)
+		}
+		return target, nil
+	})
+}
+
+// copyLocalFile copies a stored file to another path in the storage folder
+func copyLocalFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return writeStoredFile(to, data)
+}
+
+// writeStoredFile writes a file into the storage folder, readable like the
+// encoded variants
+func writeStoredFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0644)
+}
+
+// sourceVariant is the name the uploaded file is stored under, next to its variants
+const sourceVariant = "source"
+
+// sourceFormat returns the format of an uploaded image and the key extension
+// and content type it is stored with
+func sourceFormat(imageData []byte) (format, ext, contentType string) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if err != nil {
+		return "", "bin", "application/octet-stream"
+	}
+	ext = format
+	if format == "jpeg" {
+		ext = "jpg"
+	}
+	return format, ext, "image/" + format
+}
+
+// sourceKey renders the key the uploaded file is stored under
+func sourceKey(params KeyParams, ext string) string {
+	params.Ext = ext
+	return variantKey(params, sourceVariant)
+}
+
+// storeLocalSource keeps the uploaded file in the storage folder, unchanged
+func storeLocalSource(params KeyParams, imageData []byte) (VariantResult, error) {
+	started := time.Now()
+	format, ext, _ := sourceFormat(imageData)
+	path := filepath.Join("storage", sourceKey(params, ext))
+	if err := writeStoredFile(path, imageData); err != nil {
+		return VariantResult{}, err
+	}
+	result := VariantResult{Name: sourceVariant, Path: path, Bytes: int64(len(imageData)), Format: format}
+	return result.finish(started, nil), nil
+}
+
+// s3StoreSource uploads the uploaded file under destPrefix, unchanged
+func s3StoreSource(ctx context.Context, params KeyParams, profile S3Profile, imageData []byte, originalWidth, originalHeight int, destPrefix string) (VariantResult, error) {
+	started := time.Now()
+	format, ext, contentType := sourceFormat(imageData)
+	info := variantObjectInfo{
+		Variant:        sourceVariant,
+		Tenant:         params.Tenant,
+		SourceHash:     params.Hash,
+		OriginalWidth:  originalWidth,
+		OriginalHeight: originalHeight,
+	}
+	s3URL, checksum, err := uploadToS3(ctx, bytesFile{bytes.NewReader(imageData)}, destPrefix+sourceKey(params, ext), contentType, func(input *s3.PutObjectInput) {
+		profile.apply(input, info)
+	})
+	if err != nil {
+		return VariantResult{}, err
+	}
+	result := VariantResult{Name: sourceVariant, Path: s3URL, Bytes: int64(len(imageData)), Format: format, Checksum: &checksum}
+	return result.finish(started, nil), nil
+}
+
+// bytesFile lets data held in memory be uploaded like an opened file
+type bytesFile struct {
+	*bytes.Reader
+}
+
+// Close does nothing, there is nothing to release
+func (bytesFile) Close() error {
+	return nil
+}
+
+// fileSize returns the size of an encoded variant
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// variantObjectKey builds the S3 key a variant is stored under
+func variantObjectKey(destPrefix string, params KeyParams, variant string) string {
+	return destPrefix + variantKey(params, variant)
+}
+
+// s3ObjectURL returns the public URL of an object. Every segment of the key
+// is escaped, so keys holding spaces or other reserved characters stay valid.
+func s3ObjectURL(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
 	}
+	return "https://" + bucket + ".s3.amazonaws.com/" + strings.Join(segments, "/")
+}
+
+// s3ObjectKey returns the key of the object at a URL made by s3ObjectURL
+func s3ObjectKey(objectURL string) (string, error) {
+	u, err := url.Parse(objectURL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(u.Path, "/"), nil
 }
 
 // S3Imageupload uploads a file to the AWS S3 bucket
 func S3Imageupload(file multipart.File, fileName string, fileType string) (string, error) {
-	// Initialize AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(os.Getenv("AWS_BUCKET_REGION")),
-		Credentials: credentials.NewStaticCredentials(os.Getenv("AWS_ACCESS_KEY"), os.Getenv("AWS_SECRET_KEY"), ""),
-	})
+	url, _, err := uploadToS3(context.Background(), file, config.GetS3DestinationPrefix()+fileName, fileType, nil)
+	return url, err
+}
+
+// uploadToS3 uploads a file to the AWS S3 bucket under the given key.
+// configure, if not nil, can set additional object settings before the upload.
+// Every upload carries MD5 and SHA-256 checksums and is retried when S3 reports
+// that the stored object does not match them.
+// Files of at least the multipart threshold are uploaded in parts.
+func uploadToS3(ctx context.Context, file multipart.File, key string, fileType string, configure func(*s3.PutObjectInput)) (string, UploadChecksum, error) {
+	svc, err := repository.S3Client()
 	if err != nil {
 		log.Println("Failed to initialize AWS session:", err)
-		return "", fmt.Errorf("failed to initialize AWS session: %v", err)
+		return "", UploadChecksum{}, err
 	}
 
-	svc := s3.New(sess)
-	bucket := os.Getenv("AWS_BUCKET_NAME")
-	key := "imaginary/" + fileName
+	checksum, err := computeChecksum(file)
+	if err != nil {
+		return "", UploadChecksum{}, fmt.Errorf("failed to compute checksum: %v", err)
+	}
 
-	// Upload the file directly using PutObject
-	_, err = svc.PutObject(&s3.PutObjectInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(key),
-		Body:        file,                 // Use the multipart.File directly
-		ContentType: aws.String(fileType), // Ensure correct MIME type (e.g., image/jpeg)
-	})
+	size, err := file.Seek(0, io.SeekEnd)
 	if err != nil {
-		log.Printf("Failed to upload file: %v", err)
-		return "", fmt.Errorf("failed to upload file: %v", err)
+		return "", UploadChecksum{}, fmt.Errorf("failed to determine file size: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", UploadChecksum{}, fmt.Errorf("failed to rewind file: %v", err)
 	}
 
-	// Return the public URL
-	return "https://" + bucket + ".s3.amazonaws.com/" + key, nil
+	bucket := config.GetAWSBucketName()
+	attempts := config.GetS3UploadMaxAttempts()
+
+	for attempt := 1; ; attempt++ {
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			Body:        file,                 // Use the multipart.File directly
+			ContentType: aws.String(fileType), // Ensure correct MIME type (e.g., image/jpeg)
+		}
+		if configure != nil {
+			configure(input)
+		}
+
+		if size >= config.GetS3MultipartThreshold() {
+			err = uploadMultipart(ctx, svc, file, size, input)
+		} else {
+			checksum.setChecksum(input)
+
+			// Upload the file directly using PutObject
+			var out *s3.PutObjectOutput
+			out, err = svc.PutObjectWithContext(ctx, input)
+			if err == nil {
+				err = checksum.verify(input, out)
+			}
+		}
+		if err == nil {
+			// Return the public URL
+			return s3ObjectURL(bucket, key), checksum, nil
+		}
+
+		retryable := isBadDigest(err) || errors.Is(err, errChecksumMismatch)
+		if !retryable || attempt >= attempts {
+			log.Printf("Failed to upload file: %v", err)
+			return "", UploadChecksum{}, fmt.Errorf("failed to upload file: %w", err)
+		}
+
+		log.Printf("Upload of %s failed integrity check (attempt %d/%d): %v", key, attempt, attempts, err)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", UploadChecksum{}, fmt.Errorf("failed to rewind file: %v", err)
+		}
+	}
 }
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inStorageDir runs the test in an empty directory, where variants are
+// written to storage/
+func inStorageDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	previous := Cache
+	Cache = NewLRUCache(100, 0, time.Hour, true)
+	t.Cleanup(func() { Cache = previous })
+}
+
+// testPNG encodes a small image
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessDeduplicatesAcrossNames(t *testing.T) {
+	inStorageDir(t)
+	data := testPNG(t)
+	opts := UploadOptions{Tenant: DefaultTenant}
+
+	first, err := ProcessAndCompressImage(opts, "trips/photo.png", data, int64(len(data)), 40, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.CacheHit || !first.Complete() || first.Source == nil {
+		t.Fatalf("first upload: got %+v, want a complete result that was processed", first)
+	}
+
+	// The same bytes under another folder and name are not encoded again,
+	// but are stored under their own keys
+	second, err := ProcessAndCompressImage(opts, "other/copy.png", data, int64(len(data)), 40, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.CacheHit {
+		t.Error("second upload of the same bytes was processed again")
+	}
+	if second.ID == first.ID {
+		t.Error("uploads under different names share an image ID")
+	}
+	if len(second.Variants) != len(first.Variants) {
+		t.Fatalf("got %d variants, want %d", len(second.Variants), len(first.Variants))
+	}
+	for i, v := range second.Variants {
+		if !strings.HasPrefix(v.Path, "storage/other/copy_") {
+			t.Errorf("variant %s is stored at %s, want it under storage/other/copy_", v.Name, v.Path)
+		}
+		want, _ := os.ReadFile(first.Variants[i].Path)
+		if got, err := os.ReadFile(v.Path); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("variant %s was not copied: %v", v.Name, err)
+		}
+	}
+	if got, err := os.ReadFile(second.Source.Path); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("uploaded file at %s was not copied: %v", second.Source.Path, err)
+	}
+
+	// The first upload keeps its own files and record
+	for _, v := range first.Variants {
+		if _, err := os.Stat(v.Path); err != nil {
+			t.Errorf("variant of the first upload is gone: %v", err)
+		}
+	}
+	record, err := GetImageRecord(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Filename != "trips/photo.png" || record.Source == nil || record.Source.Location != first.Source.Path {
+		t.Errorf("record of the first upload was changed: %+v", record)
+	}
+}
+
+func TestProcessKeepsTheStoredDate(t *testing.T) {
+	inStorageDir(t)
+	t.Setenv("OBJECT_KEY_TEMPLATE", "{tenant}/{yyyy}/{mm}/{sha256:16}/{basename}_{variant}.{ext}")
+	data := testPNG(t)
+	opts := UploadOptions{Tenant: DefaultTenant}
+
+	first, err := ProcessAndCompressImage(opts, "photo.png", data, int64(len(data)), 40, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As if another instance sharing the cache had stored the image on another day
+	stored := time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC)
+	params := NewKeyParams(DefaultTenant, "photo", data)
+	key := cacheKey(CacheBackendLocal, DefaultTenant, contentCacheKey(params.Hash, variantSpecs(int64(len(data)), 40, 30)))
+	first.StoredAt = stored
+	Cache.Set(key, first)
+
+	second, err := ProcessAndCompressImage(opts, "copy.png", data, int64(len(data)), 40, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range append(second.Variants, *second.Source) {
+		if !strings.HasPrefix(v.Path, "storage/default/2020/01/") {
+			t.Errorf("variant %s is stored at %s, want it under the date it was first stored", v.Name, v.Path)
+		}
+	}
+	if !second.StoredAt.Equal(stored) {
+		t.Errorf("result was stored at %v, want %v", second.StoredAt, stored)
+	}
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"imaginary/photo_small.jpg", "https://bucket.s3.amazonaws.com/imaginary/photo_small.jpg"},
+		{"imaginary/my trip/photo 1.jpg", "https://bucket.s3.amazonaws.com/imaginary/my%20trip/photo%201.jpg"},
+		{"imaginary/a+b#c?d%e.jpg", "https://bucket.s3.amazonaws.com/imaginary/a+b%23c%3Fd%25e.jpg"},
+		{"imaginary/café.jpg", "https://bucket.s3.amazonaws.com/imaginary/caf%C3%A9.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got := s3ObjectURL("bucket", tt.key)
+			if got != tt.want {
+				t.Errorf("s3ObjectURL(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+			if key, err := s3ObjectKey(got); err != nil || key != tt.key {
+				t.Errorf("s3ObjectKey(%q) = %q, %v, want %q", got, key, err, tt.key)
+			}
+		})
+	}
+}
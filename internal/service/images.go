@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/internal/repository"
+)
+
+// imageIDPattern matches the IDs handed out for processed images
+var imageIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ImageRecord tells where the variants of a processed image are stored, so
+// they can be retrieved by the image ID later
+type ImageRecord struct {
+	ID        string          `json:"id"`
+	Store     string          `json:"store"`
+	Bucket    string          `json:"bucket,omitempty"` // Only set for S3
+	Tenant    string          `json:"tenant"`
+	Filename  string          `json:"filename"`
+	Variants  []StoredVariant `json:"variants"`
+	Source    *StoredVariant  `json:"source,omitempty"` // The uploaded file, kept as it was received
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// StoredVariant is a variant of a recorded image
+type StoredVariant struct {
+	Name     string `json:"name"`
+	Format   string `json:"format"`
+	Location string `json:"location"` // Local storage path or S3 object key
+}
+
+// imageID derives the ID of an image from its cache key and the key its
+// variants are stored under, so the same image stored under the same name
+// always gets the same ID
+func imageID(cacheKey, storedKey string) string {
+	sum := sha256.Sum256([]byte(cacheKey + "\n" + storedKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// recordImage remembers where the variants of result are stored and sets the
+// ID of the result. Nothing is recorded when no variant was stored. Failing to
+// record is only logged, since the variants themselves are stored.
+func recordImage(result *ImageResult, id, store, tenant, filename string) {
+	record := ImageRecord{
+		ID:        id,
+		Store:     store,
+		Tenant:    tenant,
+		Filename:  filename,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if store == CacheBackendS3 {
+		record.Bucket = config.GetAWSBucketName()
+	}
+	stored := func(v VariantResult) StoredVariant {
+		location := v.Path
+		if store == CacheBackendS3 {
+			location, _ = s3ObjectKey(v.Path)
+		}
+		return StoredVariant{Name: v.Name, Format: v.Format, Location: location}
+	}
+	for _, v := range result.Variants {
+		if v.Error == "" {
+			record.Variants = append(record.Variants, stored(v))
+		}
+	}
+	if len(record.Variants) == 0 {
+		return
+	}
+	if result.Source != nil {
+		source := stored(*result.Source)
+		record.Source = &source
+	}
+
+	result.ID = record.ID
+	if err := saveImageRecord(record); err != nil {
+		log.Printf("Failed to record image %s: %v", record.ID, err)
+	}
+}
+
+// saveImageRecord writes a record to the image directory
+func saveImageRecord(record ImageRecord) error {
+	dir := config.GetImageDir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create image directory: %v", err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode image record: %v", err)
+	}
+	return writeFileAtomic(filepath.Join(dir, record.ID+".json"), data)
+}
+
+// GetImageRecord returns the record of a processed image
+func GetImageRecord(id string) (ImageRecord, error) {
+	if !imageIDPattern.MatchString(id) {
+		return ImageRecord{}, NewError(ErrCodeNotFound, "Image not found", nil)
+	}
+	data, err := os.ReadFile(filepath.Join(config.GetImageDir(), id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return ImageRecord{}, NewError(ErrCodeNotFound, "Image not found", nil)
+	}
+	if err != nil {
+		return ImageRecord{}, NewError(ErrCodeInternal, "Failed to read image record", err)
+	}
+
+	var record ImageRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return ImageRecord{}, NewError(ErrCodeInternal, "Failed to read image record", err)
+	}
+	return record, nil
+}
+
+// OpenVariant starts reading a stored variant from the store holding it. The
+// caller must close the returned reader.
+func (r ImageRecord) OpenVariant(ctx context.Context, v StoredVariant) (io.ReadCloser, error) {
+	var (
+		body io.ReadCloser
+		err  error
+	)
+	if r.Store == CacheBackendS3 {
+		body, err = repository.OpenS3Object(ctx, r.Bucket, v.Location)
+	} else {
+		body, err = os.Open(v.Location)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, NewError(ErrCodeNotFound, fmt.Sprintf("Variant %s is no longer stored", v.Name), err)
+	}
+	if err != nil {
+		return nil, NewError(ErrCodeStorageUnavailable, fmt.Sprintf("Failed to read variant %s", v.Name), err)
+	}
+	return body, nil
+}
@@ -0,0 +1,647 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+)
+
+// maxJobBackoff caps the wait before a job is retried
+const maxJobBackoff = 5 * time.Minute
+
+// pruneInterval is how often finished jobs and webhook deliveries past their
+// retention are forgotten while the server is idle
+const pruneInterval = time.Minute
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobRetrying  = "retrying" // Waiting to be retried after a transient failure
+	JobSucceeded = "succeeded"
+	JobPartial   = "partial" // Some variants failed
+	JobFailed    = "failed"  // Dead-lettered until replayed or deleted
+)
+
+// JobInput is an upload handed to the worker pool
+type JobInput struct {
+	Store    string        `json:"store"` // CacheBackendLocal or CacheBackendS3
+	Options  UploadOptions `json:"options"`
+	Filename string        `json:"filename"`
+	Data     []byte        `json:"-"` // Spooled to the job directory, not kept in memory
+	Width    int           `json:"width"`
+	Height   int           `json:"height"`
+	// CallbackURL, if set, receives a signed webhook once the job finishes
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Job is a snapshot of an asynchronous upload
+type Job struct {
+	ID          string          `json:"id"`
+	Status      string          `json:"status"`
+	Store       string          `json:"store"`
+	Filename    string          `json:"filename"`
+	CallbackURL string          `json:"callback_url,omitempty"`
+	Width       int             `json:"width"`
+	Height      int             `json:"height"`
+	Total       int             `json:"total"`    // Variants that will be produced
+	Variants    []VariantResult `json:"variants"` // Finished variants of the current attempt, in the order they finished
+	Result      *ImageResult    `json:"result,omitempty"`
+	Err         error           `json:"-"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   time.Time       `json:"started_at"`
+	FinishedAt  time.Time       `json:"finished_at"`
+	NextAttempt time.Time       `json:"next_attempt"`
+}
+
+// Finished reports whether the job has stopped running for good
+func (j Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobPartial || j.Status == JobFailed
+}
+
+// jobRecord is the state of a job as persisted in the job directory
+type jobRecord struct {
+	Job
+	Input        JobInput `json:"input"`
+	ErrorCode    string   `json:"error_code,omitempty"`
+	ErrorMessage string   `json:"error_message,omitempty"`
+	// Webhook is the final state of the callback delivery, empty until it
+	// is delivered or has failed for good
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// JobQueue processes uploads on a fixed pool of workers. Every job and its
+// input are written to a directory, so jobs interrupted by a restart are
+// resumed. Transient failures are retried with backoff, and jobs failing for
+// good are kept as dead letters that can be replayed.
+type JobQueue struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	dir         string
+	unlock      func() error
+	jobs        map[string]*jobRecord
+	ready       []string // IDs of jobs waiting for a worker
+	reserved    int      // Queue slots taken by submissions still being written
+	queueSize   int
+	maxAttempts int
+	backoff     time.Duration
+	retention   time.Duration
+	ctx         context.Context
+}
+
+// JobQueueOptions configures a JobQueue
+type JobQueueOptions struct {
+	Dir         string
+	Workers     int
+	QueueSize   int           // Jobs that may wait for a worker before new ones are refused
+	MaxAttempts int           // Attempts before a transient failure is dead-lettered
+	Backoff     time.Duration // Wait before the first retry; it doubles with every attempt
+	Retention   time.Duration // How long finished jobs can still be queried
+}
+
+// OpenJobQueue loads the jobs in opts.Dir and starts the workers. Jobs that
+// had not finished are resumed, as are callbacks of finished jobs that were
+// never delivered. Workers stop when ctx is done, leaving their jobs to be
+// resumed on the next start.
+func OpenJobQueue(ctx context.Context, opts JobQueueOptions) (*JobQueue, error) {
+	if err := os.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %v", err)
+	}
+	lock, err := os.OpenFile(filepath.Join(opts.Dir, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job directory lock: %v", err)
+	}
+	unlock, err := lockFile(lock)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("job directory %s is in use: %v", opts.Dir, err)
+	}
+
+	q := &JobQueue{
+		dir: opts.Dir,
+		unlock: func() error {
+			defer lock.Close()
+			return unlock()
+		},
+		jobs:        make(map[string]*jobRecord),
+		queueSize:   opts.QueueSize,
+		maxAttempts: opts.MaxAttempts,
+		backoff:     opts.Backoff,
+		retention:   opts.Retention,
+		ctx:         ctx,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	q.mu.Lock()
+	undelivered, err := q.loadLocked()
+	q.mu.Unlock()
+	if err != nil {
+		q.unlock()
+		return nil, err
+	}
+
+	go q.maintain()
+	for i := 0; i < opts.Workers; i++ {
+		go q.work()
+	}
+	for _, job := range undelivered {
+		q.notify(job)
+	}
+	return q, nil
+}
+
+// Jobs runs asynchronous uploads; it is set up by ConfigureJobs
+var Jobs *JobQueue
+
+// ConfigureJobs opens the job queue configured in the environment and starts
+// its workers. Running jobs are cancelled when ctx is done. It is meant to be
+// called once at startup.
+func ConfigureJobs(ctx context.Context) error {
+	queue, err := OpenJobQueue(ctx, JobQueueOptions{
+		Dir:         config.GetJobDir(),
+		Workers:     config.GetJobWorkers(),
+		QueueSize:   config.GetJobQueueSize(),
+		MaxAttempts: config.GetJobMaxAttempts(),
+		Backoff:     config.GetJobBackoff(),
+		Retention:   config.GetJobRetention(),
+	})
+	if err != nil {
+		return err
+	}
+	Jobs = queue
+	return nil
+}
+
+// loadLocked reads every job record and schedules the jobs that had not
+// finished. It returns the finished jobs whose callback was not delivered,
+// including those pruned since. The caller must hold the lock.
+func (q *JobQueue) loadLocked() ([]Job, error) {
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+
+	resumed := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job: %v", err)
+		}
+		record := &jobRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			log.Printf("Skipping unreadable job %s: %v", path, err)
+			continue
+		}
+		if record.ErrorCode != "" {
+			record.Err = NewError(record.ErrorCode, record.ErrorMessage, nil)
+		}
+		q.jobs[record.ID] = record
+
+		switch record.Status {
+		case JobQueued, JobRunning:
+			// Work that was interrupted starts over
+			record.Status = JobQueued
+			record.Variants = nil
+			q.ready = append(q.ready, record.ID)
+			resumed++
+		case JobRetrying:
+			q.scheduleLocked(record.ID, time.Until(record.NextAttempt))
+			resumed++
+		}
+	}
+
+	// Resume in the order the jobs were submitted
+	sort.Slice(q.ready, func(i, j int) bool {
+		return q.jobs[q.ready[i]].CreatedAt.Before(q.jobs[q.ready[j]].CreatedAt)
+	})
+	if resumed > 0 {
+		log.Printf("Resuming %d unfinished jobs", resumed)
+	}
+
+	// Callbacks are collected before pruning, so an old job still gets its own
+	var undelivered []Job
+	for _, record := range q.jobs {
+		if record.Finished() && record.CallbackURL != "" && record.Webhook != DeliveryDelivered {
+			undelivered = append(undelivered, record.snapshot())
+		}
+	}
+	if len(undelivered) > 0 {
+		log.Printf("Sending %d undelivered job callbacks again", len(undelivered))
+	}
+	q.pruneLocked()
+	return undelivered, nil
+}
+
+// Submit queues an upload and returns its job without waiting for it
+func (q *JobQueue) Submit(input JobInput) (Job, error) {
+	if input.CallbackURL != "" {
+		if Webhooks == nil {
+			return Job{}, NewError(ErrCodeInvalidRequest, "Webhooks are not enabled on this server", nil)
+		}
+		if err := Webhooks.ValidateCallbackURL(input.CallbackURL); err != nil {
+			return Job{}, err
+		}
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, NewError(ErrCodeInternal, "Failed to create job", err)
+	}
+	record := &jobRecord{
+		Job: Job{
+			ID:          id,
+			Status:      JobQueued,
+			Store:       input.Store,
+			Filename:    input.Filename,
+			CallbackURL: input.CallbackURL,
+			Width:       input.Width,
+			Height:      input.Height,
+			Total:       len(variantSpecs(int64(len(input.Data)), input.Width, input.Height)),
+			CreatedAt:   time.Now(),
+		},
+		Input: input,
+	}
+	record.Input.Options.OnVariant = nil
+
+	// A slot is reserved, so the files can be written without holding the lock
+	q.mu.Lock()
+	q.pruneLocked()
+	if len(q.ready)+q.reserved >= q.queueSize {
+		q.mu.Unlock()
+		return Job{}, NewError(ErrCodeQueueFull, "Too many jobs are waiting, try again later", nil)
+	}
+	q.reserved++
+	q.mu.Unlock()
+
+	err = q.store(record, input.Data)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved--
+	if err != nil {
+		return Job{}, err
+	}
+	q.jobs[id] = record
+	q.ready = append(q.ready, id)
+	q.cond.Signal()
+	return record.snapshot(), nil
+}
+
+// store writes the input and record of a new job. The input is written
+// first, so a saved job always finds it. The record is not shared yet, so
+// the lock is not needed.
+func (q *JobQueue) store(record *jobRecord, data []byte) error {
+	if err := writeFileAtomic(q.inputPath(record.ID), data); err != nil {
+		return NewError(ErrCodeStorageUnavailable, "Failed to store job input", err)
+	}
+	if err := q.saveLocked(record); err != nil {
+		os.Remove(q.inputPath(record.ID))
+		return NewError(ErrCodeStorageUnavailable, "Failed to store job", err)
+	}
+	return nil
+}
+
+// Get returns a snapshot of a job
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	record, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return record.snapshot(), true
+}
+
+// DeadLetters returns the jobs that failed for good, oldest first
+func (q *JobQueue) DeadLetters() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := []Job{}
+	for _, record := range q.jobs {
+		if record.Status == JobFailed {
+			jobs = append(jobs, record.snapshot())
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Replay queues a dead-lettered job again with a fresh set of attempts
+func (q *JobQueue) Replay(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	record, ok := q.jobs[id]
+	if !ok {
+		return Job{}, NewError(ErrCodeNotFound, "Job not found", nil)
+	}
+	if record.Status != JobFailed {
+		return Job{}, NewError(ErrCodeInvalidRequest, "Only failed jobs can be replayed", nil)
+	}
+	if _, err := os.Stat(q.inputPath(id)); err != nil {
+		return Job{}, NewError(ErrCodeNotFound, "The input of this job is no longer available", err)
+	}
+
+	record.Status = JobQueued
+	record.Attempts = 0
+	record.Variants = nil
+	record.Result = nil
+	record.Err = nil
+	record.StartedAt = time.Time{}
+	record.FinishedAt = time.Time{}
+	record.NextAttempt = time.Time{}
+	record.Webhook = ""
+	if err := q.saveLocked(record); err != nil {
+		return Job{}, NewError(ErrCodeStorageUnavailable, "Failed to store job", err)
+	}
+	q.ready = append(q.ready, id)
+	q.cond.Signal()
+	return record.snapshot(), nil
+}
+
+// Delete removes a finished job together with its input
+func (q *JobQueue) Delete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	record, ok := q.jobs[id]
+	if !ok {
+		return NewError(ErrCodeNotFound, "Job not found", nil)
+	}
+	if !record.Finished() {
+		return NewError(ErrCodeInvalidRequest, "Only finished jobs can be deleted", nil)
+	}
+	q.removeLocked(id)
+	return nil
+}
+
+// Close releases the job directory. Workers stop once the context passed to
+// OpenJobQueue is done.
+func (q *JobQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.unlock()
+}
+
+// maintain prunes finished jobs every pruneInterval and wakes the workers once
+// the queue's context is done, so they notice it is shutting down
+func (q *JobQueue) maintain() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			q.mu.Lock()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+			return
+		case <-ticker.C:
+			q.mu.Lock()
+			q.pruneLocked()
+			q.mu.Unlock()
+		}
+	}
+}
+
+// work runs queued jobs until the queue's context is done
+func (q *JobQueue) work() {
+	for {
+		q.mu.Lock()
+		for len(q.ready) == 0 && q.ctx.Err() == nil {
+			q.cond.Wait()
+		}
+		if q.ctx.Err() != nil {
+			q.mu.Unlock()
+			return
+		}
+		record := q.jobs[q.ready[0]]
+		q.ready = q.ready[1:]
+		q.mu.Unlock()
+
+		if record != nil {
+			q.run(record)
+		}
+	}
+}
+
+// run makes one attempt at a job and records its outcome
+func (q *JobQueue) run(record *jobRecord) {
+	q.mu.Lock()
+	record.Status = JobRunning
+	record.Attempts++
+	record.Variants = nil
+	record.StartedAt = time.Now()
+	if err := q.saveLocked(record); err != nil {
+		log.Printf("Failed to store job %s: %v", record.ID, err)
+	}
+	input := record.Input
+	q.mu.Unlock()
+
+	opts := input.Options
+	opts.OnVariant = func(variant VariantResult) {
+		q.mu.Lock()
+		record.Variants = append(record.Variants, variant)
+		q.mu.Unlock()
+	}
+
+	var result ImageResult
+	data, err := os.ReadFile(q.inputPath(record.ID))
+	if err != nil {
+		err = NewError(ErrCodeNotFound, "The input of this job is no longer available", err)
+	} else {
+		result, err = processJobInput(q.ctx, input, opts, data)
+	}
+
+	// A job interrupted by shutdown stays as it is and is resumed on the next start
+	if q.ctx.Err() != nil {
+		return
+	}
+
+	q.mu.Lock()
+	if err == nil && len(result.Failed()) == len(result.Variants) {
+		err = NewError(result.FailureCode(), "No variants could be processed", nil)
+	}
+	record.Err = err
+	record.Result = nil
+	if err == nil {
+		record.Result = &result
+	}
+
+	// Storage outages pass, so those jobs are tried again
+	transient := ErrorCode(err) == ErrCodeStorageUnavailable || (err == nil && result.FailureCode() == ErrCodeStorageUnavailable)
+	if transient && record.Attempts < q.maxAttempts {
+		wait := q.backoff << (record.Attempts - 1)
+		if wait <= 0 || wait > maxJobBackoff {
+			wait = maxJobBackoff
+		}
+		record.Status = JobRetrying
+		record.NextAttempt = time.Now().Add(wait)
+		if err := q.saveLocked(record); err != nil {
+			log.Printf("Failed to store job %s: %v", record.ID, err)
+		}
+		q.scheduleLocked(record.ID, wait)
+		q.mu.Unlock()
+		log.Printf("Job %s attempt %d failed, retrying in %s", record.ID, record.Attempts, wait)
+		return
+	}
+
+	record.FinishedAt = time.Now()
+	record.NextAttempt = time.Time{}
+	switch {
+	case err != nil:
+		record.Status = JobFailed
+		log.Printf("Job %s failed after %d attempts: %v", record.ID, record.Attempts, err)
+	case len(result.Failed()) > 0:
+		record.Status = JobPartial
+	default:
+		record.Status = JobSucceeded
+	}
+	if err := q.saveLocked(record); err != nil {
+		log.Printf("Failed to store job %s: %v", record.ID, err)
+	}
+	// Only dead-lettered jobs need their input again
+	if record.Status != JobFailed {
+		os.Remove(q.inputPath(record.ID))
+	}
+	job := record.snapshot()
+	q.mu.Unlock()
+
+	q.notify(job)
+}
+
+// notify sends the callback of a finished job and records its final state,
+// so a callback cut short by a restart is sent again
+func (q *JobQueue) notify(job Job) {
+	if job.CallbackURL == "" || Webhooks == nil {
+		return
+	}
+	Webhooks.Notify(job.CallbackURL, job, func(status string) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		// The job may have been pruned, deleted or replayed meanwhile
+		record, ok := q.jobs[job.ID]
+		if !ok || !record.FinishedAt.Equal(job.FinishedAt) {
+			return
+		}
+		record.Webhook = status
+		if err := q.saveLocked(record); err != nil {
+			log.Printf("Failed to store job %s: %v", record.ID, err)
+		}
+	})
+}
+
+// processJobInput processes the input of a job into its store. It is a
+// variable so tests can stand in for the image pipeline.
+var processJobInput = func(ctx context.Context, input JobInput, opts UploadOptions, data []byte) (ImageResult, error) {
+	if input.Store == CacheBackendS3 {
+		return S3ProcessAndCompressImage(ctx, opts, input.Filename, data, int64(len(data)), input.Width, input.Height)
+	}
+	return ProcessAndCompressImage(opts, input.Filename, data, int64(len(data)), input.Width, input.Height)
+}
+
+// scheduleLocked queues a job again once wait has passed; the caller must hold the lock
+func (q *JobQueue) scheduleLocked(id string, wait time.Duration) {
+	time.AfterFunc(wait, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if record, ok := q.jobs[id]; ok && record.Status == JobRetrying {
+			record.Status = JobQueued
+			q.ready = append(q.ready, id)
+			q.cond.Signal()
+		}
+	})
+}
+
+// saveLocked writes the record of a job; the caller must hold the lock
+func (q *JobQueue) saveLocked(record *jobRecord) error {
+	record.ErrorCode, record.ErrorMessage = "", ""
+	if record.Err != nil {
+		record.ErrorCode = ErrorCode(record.Err)
+		record.ErrorMessage = "Failed to process image"
+		var serviceErr *Error
+		if errors.As(record.Err, &serviceErr) {
+			record.ErrorMessage = serviceErr.Message
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.recordPath(record.ID), data)
+}
+
+// removeLocked forgets a job and deletes its files; the caller must hold the lock
+func (q *JobQueue) removeLocked(id string) {
+	delete(q.jobs, id)
+	os.Remove(q.recordPath(id))
+	os.Remove(q.inputPath(id))
+}
+
+// pruneLocked forgets successful jobs that finished more than the retention
+// ago. Dead letters are kept until they are replayed or deleted. The caller
+// must hold the lock.
+func (q *JobQueue) pruneLocked() {
+	cutoff := time.Now().Add(-q.retention)
+	for id, record := range q.jobs {
+		if record.Finished() && record.Status != JobFailed && record.FinishedAt.Before(cutoff) {
+			q.removeLocked(id)
+		}
+	}
+}
+
+// recordPath returns the file holding the state of a job
+func (q *JobQueue) recordPath(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// inputPath returns the file holding the upload of a job
+func (q *JobQueue) inputPath(id string) string {
+	return filepath.Join(q.dir, id+".input")
+}
+
+// snapshot copies a job so callers can use it without the lock
+func (r *jobRecord) snapshot() Job {
+	job := r.Job
+	job.Variants = append([]VariantResult(nil), r.Variants...)
+	return job
+}
+
+// writeFileAtomic replaces path with data, so a crash never leaves a partial file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// newJobID returns a random job identifier
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jobRunner stands in for the image pipeline and records every attempt
+type jobRunner struct {
+	mu    sync.Mutex
+	calls []string // Filenames, in the order they were processed
+	times []time.Time
+	err   func(attempt int) error // Error of the given attempt, counted from 1
+}
+
+// install replaces processJobInput with the runner until the test ends
+func (r *jobRunner) install(t *testing.T) {
+	t.Helper()
+	previous := processJobInput
+	processJobInput = func(ctx context.Context, input JobInput, opts UploadOptions, data []byte) (ImageResult, error) {
+		r.mu.Lock()
+		r.calls = append(r.calls, input.Filename)
+		r.times = append(r.times, time.Now())
+		attempt := len(r.calls)
+		r.mu.Unlock()
+		if r.err != nil {
+			if err := r.err(attempt); err != nil {
+				return ImageResult{}, err
+			}
+		}
+		return ImageResult{Variants: []VariantResult{{Name: "small", Path: "storage/" + input.Filename, Format: "jpeg"}}}, nil
+	}
+	t.Cleanup(func() { processJobInput = previous })
+}
+
+// attempts returns the filenames processed so far
+func (r *jobRunner) attempts() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+// openTestQueue opens a queue in dir that stops when the test ends
+func openTestQueue(t *testing.T, opts JobQueueOptions) *JobQueue {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	q, err := OpenJobQueue(ctx, opts)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		q.Close()
+	})
+	return q
+}
+
+// writeTestJob stores a job and its input as an earlier run would have left them
+func writeTestJob(t *testing.T, dir string, record jobRecord) {
+	t.Helper()
+	record.Input.Store = CacheBackendLocal
+	record.Input.Filename = record.Filename
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, record.ID+".json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, record.ID+".input"), []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForJob waits until the job has the given status
+func waitForJob(t *testing.T, q *JobQueue, id, status string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := q.Get(id)
+		if ok && job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %q, want %q", id, job.Status, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJobQueueResumesAfterRestart(t *testing.T) {
+	runner := &jobRunner{}
+	runner.install(t)
+	dir := t.TempDir()
+	created := time.Now().Add(-time.Hour)
+
+	unfinished := []jobRecord{
+		{Job: Job{ID: "running", Status: JobRunning, Filename: "running.jpg", Attempts: 1, CreatedAt: created.Add(2 * time.Second)}},
+		{Job: Job{ID: "queued", Status: JobQueued, Filename: "queued.jpg", CreatedAt: created.Add(time.Second)}},
+	}
+	// Retries that were due while the server was down run right away
+	for i := 0; i < 20; i++ {
+		unfinished = append(unfinished, jobRecord{Job: Job{
+			ID:          fmt.Sprintf("retrying-%d", i),
+			Status:      JobRetrying,
+			Filename:    fmt.Sprintf("retrying-%d.jpg", i),
+			Attempts:    1,
+			CreatedAt:   created,
+			NextAttempt: time.Now().Add(-time.Minute),
+		}})
+	}
+	for _, record := range unfinished {
+		writeTestJob(t, dir, record)
+	}
+	writeTestJob(t, dir, jobRecord{Job: Job{ID: "later", Status: JobRetrying, Filename: "later.jpg", Attempts: 1, CreatedAt: created, NextAttempt: time.Now().Add(time.Hour)}})
+
+	q := openTestQueue(t, JobQueueOptions{Dir: dir, Workers: 1, QueueSize: 100, MaxAttempts: 3, Backoff: time.Millisecond, Retention: time.Hour})
+	for _, record := range unfinished {
+		job := waitForJob(t, q, record.ID, JobSucceeded)
+		if job.Attempts != record.Attempts+1 {
+			t.Errorf("job %s made %d attempts, want %d", record.ID, job.Attempts, record.Attempts+1)
+		}
+		if _, err := os.Stat(filepath.Join(dir, record.ID+".input")); !os.IsNotExist(err) {
+			t.Errorf("input of succeeded job %s was kept", record.ID)
+		}
+	}
+
+	// Interrupted jobs resume in the order they were submitted, before the retries
+	attempts := runner.attempts()
+	if len(attempts) != len(unfinished) || attempts[0] != "queued.jpg" || attempts[1] != "running.jpg" {
+		t.Errorf("processed %q, want queued.jpg and running.jpg first and %d jobs in all", attempts, len(unfinished))
+	}
+	if job, _ := q.Get("later"); job.Status != JobRetrying {
+		t.Errorf("job that is not due yet is %q, want %q", job.Status, JobRetrying)
+	}
+}
+
+func TestJobQueueRetriesAndDeadLetters(t *testing.T) {
+	runner := &jobRunner{err: func(attempt int) error {
+		if attempt <= 3 {
+			return NewError(ErrCodeStorageUnavailable, "Storage is down", nil)
+		}
+		return nil
+	}}
+	runner.install(t)
+	dir := t.TempDir()
+	backoff := 20 * time.Millisecond
+	q := openTestQueue(t, JobQueueOptions{Dir: dir, Workers: 1, QueueSize: 10, MaxAttempts: 3, Backoff: backoff, Retention: time.Hour})
+
+	submitted, err := q.Submit(JobInput{Store: CacheBackendLocal, Filename: "photo.jpg", Data: []byte("image")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := waitForJob(t, q, submitted.ID, JobFailed)
+	if job.Attempts != 3 {
+		t.Errorf("job made %d attempts, want 3", job.Attempts)
+	}
+	if ErrorCode(job.Err) != ErrCodeStorageUnavailable {
+		t.Errorf("job failed with %v, want %s", job.Err, ErrCodeStorageUnavailable)
+	}
+
+	// The wait doubles after every attempt
+	runner.mu.Lock()
+	times := append([]time.Time(nil), runner.times...)
+	runner.mu.Unlock()
+	for i := 1; i < len(times); i++ {
+		want := backoff << (i - 1)
+		if got := times[i].Sub(times[i-1]); got < want {
+			t.Errorf("attempt %d started %v after the previous one, want at least %v", i+1, got, want)
+		}
+	}
+
+	dead := q.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != submitted.ID {
+		t.Fatalf("got dead letters %+v, want job %s", dead, submitted.ID)
+	}
+	if _, err := os.Stat(filepath.Join(dir, submitted.ID+".input")); err != nil {
+		t.Fatalf("input of a dead letter was not kept: %v", err)
+	}
+
+	// A dead letter survives a restart and can be replayed
+	q.Close()
+	q = openTestQueue(t, JobQueueOptions{Dir: dir, Workers: 1, QueueSize: 10, MaxAttempts: 3, Backoff: backoff, Retention: time.Hour})
+	if dead := q.DeadLetters(); len(dead) != 1 || ErrorCode(dead[0].Err) != ErrCodeStorageUnavailable {
+		t.Fatalf("got dead letters %+v after a restart, want the failed job", dead)
+	}
+	if _, err := q.Replay(submitted.ID); err != nil {
+		t.Fatal(err)
+	}
+	job = waitForJob(t, q, submitted.ID, JobSucceeded)
+	if job.Attempts != 1 {
+		t.Errorf("replayed job made %d attempts, want 1", job.Attempts)
+	}
+	if len(q.DeadLetters()) != 0 {
+		t.Error("replayed job is still a dead letter")
+	}
+}
+
+func TestJobQueueDeadLettersPermanentFailures(t *testing.T) {
+	runner := &jobRunner{err: func(int) error {
+		return NewError(ErrCodeUnsupportedFormat, "Unsupported image format", nil)
+	}}
+	runner.install(t)
+	q := openTestQueue(t, JobQueueOptions{Dir: t.TempDir(), Workers: 1, QueueSize: 10, MaxAttempts: 3, Backoff: time.Millisecond, Retention: time.Hour})
+
+	submitted, err := q.Submit(JobInput{Store: CacheBackendLocal, Filename: "photo.jpg", Data: []byte("image")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := waitForJob(t, q, submitted.ID, JobFailed); job.Attempts != 1 {
+		t.Errorf("job made %d attempts, want 1", job.Attempts)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	dir := t.TempDir()
+	q := openTestQueue(t, JobQueueOptions{Dir: dir, Workers: 0, QueueSize: 1, MaxAttempts: 3, Backoff: time.Millisecond, Retention: time.Hour})
+
+	job, err := q.Submit(JobInput{Store: CacheBackendLocal, Filename: "photo.jpg", Data: []byte("image")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, job.ID+".input")); err != nil || string(data) != "image" {
+		t.Errorf("job input holds %q, %v", data, err)
+	}
+	if _, err := q.Submit(JobInput{Store: CacheBackendLocal, Filename: "other.jpg", Data: []byte("image")}); ErrorCode(err) != ErrCodeQueueFull {
+		t.Errorf("got %v, want %s", err, ErrCodeQueueFull)
+	}
+}
+
+func TestJobQueueResendsUndeliveredCallbacks(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload.JobID
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	previous := Webhooks
+	Webhooks = NewWebhookDispatcher(ctx, "secret", 1, time.Millisecond, time.Hour, true)
+	defer func() { Webhooks = previous }()
+
+	dir := t.TempDir()
+	finished := time.Now().Add(-2 * time.Hour)
+	// The first job is past the retention but its callback never went out
+	writeTestJob(t, dir, jobRecord{Job: Job{ID: "expired", Status: JobSucceeded, CallbackURL: server.URL, FinishedAt: finished}})
+	writeTestJob(t, dir, jobRecord{Job: Job{ID: "delivered", Status: JobSucceeded, CallbackURL: server.URL, FinishedAt: time.Now()}, Webhook: DeliveryDelivered})
+	writeTestJob(t, dir, jobRecord{Job: Job{ID: "failed", Status: JobFailed, CallbackURL: server.URL, FinishedAt: time.Now()}})
+
+	q := openTestQueue(t, JobQueueOptions{Dir: dir, Workers: 1, QueueSize: 10, MaxAttempts: 3, Backoff: time.Millisecond, Retention: time.Hour})
+
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case id := <-received:
+			got[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received callbacks for %v, want expired and failed", got)
+		}
+	}
+	if !got["expired"] || !got["failed"] {
+		t.Errorf("received callbacks for %v, want expired and failed", got)
+	}
+	select {
+	case id := <-received:
+		t.Errorf("unexpected callback for %s", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, ok := q.Get("expired"); ok {
+		t.Error("job past the retention was not pruned")
+	}
+
+	// The outcome is recorded, so the callback is not sent again on the next start
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(filepath.Join(dir, "failed.json"))
+		var record jobRecord
+		if json.Unmarshal(data, &record) == nil && record.Webhook == DeliveryDelivered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("delivery of the failed job was not recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+)
+
+// DefaultTenant is used when a request does not name a tenant
+const DefaultTenant = "default"
+
+// keyToken matches placeholders such as {variant} or {sha256:8}
+var keyToken = regexp.MustCompile(`\{([a-z0-9]+)(?::(\d+))?\}`)
+
+// tenantPattern restricts tenant names to characters safe in paths and keys
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// minUniqueHashLength is the shortest {sha256:N} that keeps keys of distinct
+// images apart on its own; shorter hashes also need {basename}
+const minUniqueHashLength = 16
+
+// KeyParams holds the values substituted into an object key template
+type KeyParams struct {
+	Tenant   string
+	BaseName string
+	Variant  string
+	Ext      string
+	Hash     string    // Hex SHA-256 of the original image bytes
+	Time     time.Time // Day the image was first stored, filling the date placeholders
+}
+
+// NewKeyParams prepares the key values shared by every variant of one image.
+// The date is today; an image found in the cache takes the date it was first
+// stored with instead, so its keys stay the same.
+func NewKeyParams(tenant, baseName string, imageData []byte) KeyParams {
+	sum := sha256.Sum256(imageData)
+	return KeyParams{
+		Tenant:   tenant,
+		BaseName: baseName,
+		Ext:      "jpg",
+		Hash:     hex.EncodeToString(sum[:]),
+		Time:     time.Now().UTC(),
+	}
+}
+
+// SanitizeTenant returns a tenant name that is safe to use in keys
+func SanitizeTenant(tenant string) string {
+	if !tenantPattern.MatchString(tenant) {
+		return DefaultTenant
+	}
+	return tenant
+}
+
+// ValidateKeyTemplate makes sure a template only uses known placeholders and
+// yields a distinct key for every variant of every distinct image
+func ValidateKeyTemplate(template string) error {
+	seen := map[string]bool{}
+	hashLength := sha256.Size * 2
+	for _, m := range keyToken.FindAllStringSubmatch(template, -1) {
+		switch m[1] {
+		case "tenant", "yyyy", "mm", "dd", "basename", "variant", "ext":
+		case "sha256":
+			if m[2] != "" {
+				n, _ := strconv.Atoi(m[2])
+				if n < 8 || n > sha256.Size*2 {
+					return fmt.Errorf("sha256 length must be between 8 and %d", sha256.Size*2)
+				}
+				hashLength = min(hashLength, n)
+			}
+		default:
+			return fmt.Errorf("unknown placeholder {%s}", m[1])
+		}
+		seen[m[1]] = true
+	}
+
+	if !seen["variant"] {
+		return fmt.Errorf("template must contain {variant}")
+	}
+	if !seen["sha256"] {
+		return fmt.Errorf("template must contain {sha256} or {sha256:N}")
+	}
+	// Short hashes of distinct images collide too easily without the name
+	if hashLength < minUniqueHashLength && !seen["basename"] {
+		return fmt.Errorf("{sha256:N} shorter than %d needs {basename} as well", minUniqueHashLength)
+	}
+	return nil
+}
+
+// RenderKey fills a key template for a single variant. A base name may hold
+// folders, such as the path of a file inside an archive; when the template
+// has no {basename}, those folders are placed before the last key segment, so
+// the folder structure is kept either way.
+func RenderKey(template string, p KeyParams) string {
+	key := keyToken.ReplaceAllStringFunc(template, func(token string) string {
+		m := keyToken.FindStringSubmatch(token)
+		switch m[1] {
+		case "tenant":
+			return p.Tenant
+		case "yyyy":
+			return fmt.Sprintf("%04d", p.Time.Year())
+		case "mm":
+			return fmt.Sprintf("%02d", int(p.Time.Month()))
+		case "dd":
+			return fmt.Sprintf("%02d", p.Time.Day())
+		case "basename":
+			return p.BaseName
+		case "variant":
+			return p.Variant
+		case "ext":
+			return p.Ext
+		case "sha256":
+			if n, err := strconv.Atoi(m[2]); err == nil && n < len(p.Hash) {
+				return p.Hash[:n]
+			}
+			return p.Hash
+		}
+		return token
+	})
+
+	parts := strings.Split(key, "/")
+	if folder := path.Dir(p.BaseName); folder != "." && !strings.Contains(template, "{basename}") {
+		last := len(parts) - 1
+		parts = append(append(parts[:last:last], strings.Split(folder, "/")...), parts[last])
+	}
+
+	// Never let a key escape the storage folder or bucket prefix
+	clean := parts[:0]
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		clean = append(clean, part)
+	}
+	return strings.Join(clean, "/")
+}
+
+// variantKey renders the configured key template for one variant
+func variantKey(p KeyParams, variant string) string {
+	p.Variant = variant
+	return RenderKey(config.GetKeyTemplate(), p)
+}
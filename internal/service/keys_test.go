@@ -0,0 +1,29 @@
+package service
+
+import "testing"
+
+func TestValidateKeyTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{"{basename}_{sha256:8}_{variant}.{ext}", true},
+		{"{tenant}/{yyyy}/{mm}/{sha256:16}/{variant}.{ext}", true},
+		{"{tenant}/{sha256}/{variant}.{ext}", true},
+		{"{tenant}/{yyyy}/{mm}/{sha256:8}/{variant}.{ext}", false}, // Short hash without the name
+		{"{tenant}/{sha256:15}/{variant}.{ext}", false},
+		{"{basename}_{sha256:4}_{variant}.{ext}", false},
+		{"{basename}_{sha256:65}_{variant}.{ext}", false},
+		{"{basename}_{variant}.{ext}", false},
+		{"{basename}_{sha256}.{ext}", false},
+		{"{basename}_{sha256}_{variant}_{size}.{ext}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			err := ValidateKeyTemplate(tt.template)
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateKeyTemplate(%q) = %v, want valid %v", tt.template, err, tt.valid)
+			}
+		})
+	}
+}
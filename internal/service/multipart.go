@@ -0,0 +1,241 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// minPartSize is the smallest part S3 accepts, except for the last one
+const minPartSize = 5 * 1024 * 1024
+
+// uploadPartResult is the outcome of uploading one part
+type uploadPartResult struct {
+	part   *s3.CompletedPart
+	md5    []byte
+	sha256 []byte
+	err    error
+}
+
+// uploadMultipart uploads a large file in concurrent parts using the settings of
+// input. The upload is aborted if any part fails or ctx is cancelled, so no
+// incomplete parts are left behind in the bucket.
+func uploadMultipart(ctx context.Context, svc *s3.S3, file io.ReaderAt, size int64, input *s3.PutObjectInput) error {
+	created, err := svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		ContentType:          input.ContentType,
+		CacheControl:         input.CacheControl,
+		ContentDisposition:   input.ContentDisposition,
+		StorageClass:         input.StorageClass,
+		ServerSideEncryption: input.ServerSideEncryption,
+		SSEKMSKeyId:          input.SSEKMSKeyId,
+		Metadata:             input.Metadata,
+		Tagging:              input.Tagging,
+		ChecksumAlgorithm:    aws.String(s3.ChecksumAlgorithmSha256),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+	uploadID := created.UploadId
+
+	parts, err := uploadParts(ctx, svc, file, size, input, uploadID)
+	if err != nil {
+		abortMultipart(svc, input, uploadID)
+		return err
+	}
+
+	completed, err := svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts.completed},
+	})
+	if err != nil {
+		abortMultipart(svc, input, uploadID)
+		return fmt.Errorf("failed to complete multipart upload: %v", err)
+	}
+
+	if sum := aws.StringValue(completed.ChecksumSHA256); sum != "" && sum != parts.checksumSHA256() {
+		return fmt.Errorf("%w: sha256 %s, expected %s", errChecksumMismatch, sum, parts.checksumSHA256())
+	}
+	// The ETag of a multipart object is the MD5 of the part MD5s, unless KMS is used
+	if isKMSEncryption(aws.StringValue(input.ServerSideEncryption)) {
+		return nil
+	}
+	etag := strings.Trim(aws.StringValue(completed.ETag), `"`)
+	if etag != "" && etag != parts.etag() {
+		return fmt.Errorf("%w: etag %s, expected %s", errChecksumMismatch, etag, parts.etag())
+	}
+	return nil
+}
+
+// uploadedParts holds the parts of a finished upload in part number order
+type uploadedParts struct {
+	completed []*s3.CompletedPart
+	md5s      [][]byte
+	sha256s   [][]byte
+}
+
+// checksumSHA256 computes the checksum S3 reports for an object made of these
+// parts: the SHA-256 of the part digests, followed by the part count
+func (p uploadedParts) checksumSHA256() string {
+	sum := sha256.Sum256(bytes.Join(p.sha256s, nil))
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(sum[:]), len(p.sha256s))
+}
+
+// etag computes the ETag S3 reports for an object made of these parts
+func (p uploadedParts) etag() string {
+	sum := md5.Sum(bytes.Join(p.md5s, nil))
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), len(p.md5s))
+}
+
+// uploadParts uploads every part with a bounded number of workers
+func uploadParts(ctx context.Context, svc *s3.S3, file io.ReaderAt, size int64, input *s3.PutObjectInput, uploadID *string) (uploadedParts, error) {
+	partSize := config.GetS3MultipartPartSize()
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	count := int((size + partSize - 1) / partSize)
+
+	// Stop the remaining parts as soon as one of them fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	numbers := make(chan int64)
+	results := make(chan uploadPartResult, count)
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.GetS3MultipartConcurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				offset := (number - 1) * partSize
+				length := partSize
+				if offset+length > size {
+					length = size - offset
+				}
+				result := uploadPart(ctx, svc, io.NewSectionReader(file, offset, length), input, uploadID, number)
+				if result.err != nil {
+					cancel()
+				}
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		defer close(numbers)
+		for number := int64(1); number <= int64(count); number++ {
+			select {
+			case numbers <- number:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Wait for every worker so no part is still in flight when the upload is aborted
+	var collected []uploadPartResult
+	var firstErr error
+	for result := range results {
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			continue
+		}
+		collected = append(collected, result)
+	}
+	if firstErr != nil {
+		return uploadedParts{}, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return uploadedParts{}, err
+	}
+	if len(collected) != count {
+		return uploadedParts{}, fmt.Errorf("only %d of %d parts were uploaded", len(collected), count)
+	}
+
+	sort.Slice(collected, func(i, j int) bool {
+		return aws.Int64Value(collected[i].part.PartNumber) < aws.Int64Value(collected[j].part.PartNumber)
+	})
+	var parts uploadedParts
+	for _, result := range collected {
+		parts.completed = append(parts.completed, result.part)
+		parts.md5s = append(parts.md5s, result.md5)
+		parts.sha256s = append(parts.sha256s, result.sha256)
+	}
+	return parts, nil
+}
+
+// uploadPart uploads a single part together with its checksums
+func uploadPart(ctx context.Context, svc *s3.S3, body *io.SectionReader, input *s3.PutObjectInput, uploadID *string, number int64) uploadPartResult {
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), body); err != nil {
+		return uploadPartResult{err: fmt.Errorf("failed to read part %d: %v", number, err)}
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return uploadPartResult{err: fmt.Errorf("failed to rewind part %d: %v", number, err)}
+	}
+	partMD5 := md5Hash.Sum(nil)
+	partDigest := sha256Hash.Sum(nil)
+	partSHA256 := base64.StdEncoding.EncodeToString(partDigest)
+
+	out, err := svc.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:            input.Bucket,
+		Key:               input.Key,
+		UploadId:          uploadID,
+		PartNumber:        aws.Int64(number),
+		Body:              body,
+		ContentMD5:        aws.String(base64.StdEncoding.EncodeToString(partMD5)),
+		ChecksumAlgorithm: aws.String(s3.ChecksumAlgorithmSha256),
+		ChecksumSHA256:    aws.String(partSHA256),
+	})
+	if err != nil {
+		return uploadPartResult{err: fmt.Errorf("failed to upload part %d: %w", number, err)}
+	}
+
+	return uploadPartResult{
+		part: &s3.CompletedPart{
+			ETag:           out.ETag,
+			PartNumber:     aws.Int64(number),
+			ChecksumSHA256: aws.String(partSHA256),
+		},
+		md5:    partMD5,
+		sha256: partDigest,
+	}
+}
+
+// abortMultipart discards the parts of a failed upload. It does not use the
+// request context, which may already be cancelled.
+func abortMultipart(svc *s3.S3, input *s3.PutObjectInput, uploadID *string) {
+	_, err := svc.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   input.Bucket,
+		Key:      input.Key,
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Printf("Failed to abort multipart upload of %s: %v", aws.StringValue(input.Key), err)
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestUploadedPartsChecksums(t *testing.T) {
+	tests := []struct {
+		name   string
+		parts  []string
+		etag   string
+		sha256 string
+	}{
+		{"one part", []string{"hello world"}, "241d8a27c836427bd7f04461b60e7359-1", "vGLUuA2eNtopwWxdTZ8Rcx82BSxyQBp2wjwPtam3RCM=-1"},
+		{"two parts", []string{"hello ", "world"}, "e09e4fd6265b36115fe3db32df945d84-2", "Zhie15keHg/OBlOZxcoF/BXCgYZaeimRvdZnwUZqkaQ=-2"},
+		{"short last part", []string{"aaaaa", "bbbbb", "c"}, "7f6a87eb8f486f0176013cf025601f57-3", "0W2c7p+6tw5AoTujakVNsyvPh5crkL2ks8MamfEouu0=-3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parts uploadedParts
+			for _, part := range tt.parts {
+				md5Sum := md5.Sum([]byte(part))
+				sha256Sum := sha256.Sum256([]byte(part))
+				parts.md5s = append(parts.md5s, md5Sum[:])
+				parts.sha256s = append(parts.sha256s, sha256Sum[:])
+			}
+			if got := parts.etag(); got != tt.etag {
+				t.Errorf("etag() = %s, want %s", got, tt.etag)
+			}
+			if got := parts.checksumSHA256(); got != tt.sha256 {
+				t.Errorf("checksumSHA256() = %s, want %s", got, tt.sha256)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/pkg/redis_client"
+)
+
+// RedisCache shares processed results between instances through a server
+// speaking the Redis protocol. Keys are prefixed so several deployments can
+// use the same database, and expiry is left to the server.
+type RedisCache struct {
+	client   *redis_client.Client
+	prefix   string
+	ttl      time.Duration
+	validate bool
+
+	mu    sync.Mutex
+	stats CacheStats // Counters of this instance only
+}
+
+// NewRedisCache creates a cache storing results under prefix for ttl
+func NewRedisCache(client *redis_client.Client, prefix string, ttl time.Duration, validate bool) *RedisCache {
+	return &RedisCache{client: client, prefix: prefix, ttl: ttl, validate: validate}
+}
+
+// Get returns the result stored under key if it is still valid. An unreachable
+// server is treated as a miss so uploads keep working.
+func (c *RedisCache) Get(key string) (ImageResult, bool) {
+	value, err := c.client.Get(c.prefix + key)
+	if err != nil {
+		if !errors.Is(err, redis_client.ErrNil) {
+			log.Printf("Redis cache lookup failed: %v", err)
+		}
+		c.count(func(s *CacheStats) { s.Misses++ })
+		return ImageResult{}, false
+	}
+
+	var result ImageResult
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		log.Printf("Redis cache entry %s is invalid: %v", key, err)
+		c.client.Del(c.prefix + key)
+		c.count(func(s *CacheStats) { s.Misses++; s.Stale++ })
+		return ImageResult{}, false
+	}
+
+	if c.validate && !resultExists(result) {
+		c.client.Del(c.prefix + key)
+		c.count(func(s *CacheStats) { s.Misses++; s.Stale++ })
+		return ImageResult{}, false
+	}
+
+	c.count(func(s *CacheStats) { s.Hits++ })
+	result.CacheHit = true
+	return result, true
+}
+
+// Set stores a result on the server
+func (c *RedisCache) Set(key string, result ImageResult) {
+	result.CacheHit = false
+	value, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to encode cache entry %s: %v", key, err)
+		return
+	}
+	if err := c.client.Set(c.prefix+key, string(value), c.ttl); err != nil {
+		log.Printf("Redis cache store failed: %v", err)
+	}
+}
+
+// Stats returns the counters of this instance. Entries and Bytes are not
+// tracked, since the data is shared with other instances.
+func (c *RedisCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// count updates the counters under the lock
+func (c *RedisCache) count(update func(*CacheStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update(&c.stats)
+}
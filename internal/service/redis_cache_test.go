@@ -0,0 +1,121 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/pkg/redis_client"
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestRedisCache returns a cache backed by a miniredis server closed with the test
+func newTestRedisCache(t *testing.T, prefix string, ttl time.Duration) (*RedisCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis_client.NewClient(redis_client.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisCache(client, prefix, ttl, true), server
+}
+
+// storedResult returns a complete result whose files exist
+func storedResult(t *testing.T) ImageResult {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"photo_small.jpg", "photo_source.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ImageResult{
+		Variants: []VariantResult{{Name: "small", Path: filepath.Join(dir, "photo_small.jpg"), Bytes: 5, Width: 320, Height: 240, Format: "jpeg"}},
+		Source:   &VariantResult{Name: "source", Path: filepath.Join(dir, "photo_source.png"), Bytes: 5, Format: "png"},
+	}
+}
+
+func TestRedisCacheRoundTrip(t *testing.T) {
+	cache, server := newTestRedisCache(t, "app:", time.Hour)
+	result := storedResult(t)
+	key := cacheKey(CacheBackendLocal, "acme", "abc")
+
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("Get of an unknown key hit")
+	}
+	cache.Set(key, result)
+
+	if server.Exists(key) {
+		t.Error("key was stored without the prefix")
+	}
+	if !server.Exists("app:" + key) {
+		t.Fatal("key was not stored under the prefix")
+	}
+	if ttl := server.TTL("app:" + key); ttl != time.Hour {
+		t.Errorf("entry expires in %v, want 1h", ttl)
+	}
+
+	got, ok := cache.Get(key)
+	if !ok {
+		t.Fatal("Get of a stored key missed")
+	}
+	if !got.CacheHit {
+		t.Error("CacheHit is not set on a cached result")
+	}
+	if !reflect.DeepEqual(got.Variants, result.Variants) {
+		t.Errorf("got variants %+v, want %+v", got.Variants, result.Variants)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("got %d hits and %d misses, want 1 and 1", stats.Hits, stats.Misses)
+	}
+}
+
+func TestRedisCacheExpiry(t *testing.T) {
+	cache, server := newTestRedisCache(t, "app:", 20*time.Millisecond)
+	cache.Set("key", storedResult(t))
+	server.FastForward(50 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Get of an expired entry hit")
+	}
+}
+
+func TestRedisCacheDropsStaleEntries(t *testing.T) {
+	cache, server := newTestRedisCache(t, "app:", time.Hour)
+
+	result := storedResult(t)
+	cache.Set("missing-file", result)
+	os.Remove(result.Variants[0].Path)
+	if _, ok := cache.Get("missing-file"); ok {
+		t.Error("Get returned a result whose file is gone")
+	}
+	if server.Exists("app:missing-file") {
+		t.Error("entry whose file is gone was not deleted")
+	}
+
+	if err := server.Set("app:invalid", "{"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("invalid"); ok {
+		t.Error("Get returned an entry that is not valid JSON")
+	}
+	if server.Exists("app:invalid") {
+		t.Error("entry that is not valid JSON was not deleted")
+	}
+
+	if stats := cache.Stats(); stats.Stale != 2 || stats.Misses != 2 {
+		t.Errorf("got %d stale and %d misses, want 2 and 2", stats.Stale, stats.Misses)
+	}
+}
+
+func TestRedisCacheUnreachable(t *testing.T) {
+	cache, server := newTestRedisCache(t, "app:", time.Hour)
+	server.Close()
+
+	// Uploads keep working without the server
+	cache.Set("key", storedResult(t))
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Get hit without a server")
+	}
+}
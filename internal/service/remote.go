@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/server"
+)
+
+var (
+	errBlockedAddress    = errors.New("address is not allowed")
+	errTooManyRedirects  = errors.New("too many redirects")
+	errUnsupportedScheme = errors.New("unsupported URL scheme")
+)
+
+// blockedNetworks are reserved ranges not covered by the net.IP predicates
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",      // "This" network
+	"100.64.0.0/10",  // Carrier-grade NAT
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // Benchmarking
+	"240.0.0.0/4",    // Reserved
+	"64:ff9b::/96",   // NAT64, which embeds IPv4 addresses
+	"64:ff9b:1::/48", // Local-use NAT64
+)
+
+// RemoteImage is an image downloaded from a URL
+type RemoteImage struct {
+	Filename string
+	Data     []byte
+}
+
+var (
+	remoteClientOnce sync.Once
+	remoteClient     *http.Client
+)
+
+// remoteHTTPClient returns a client for fetching untrusted URLs. It checks
+// every address it connects to, see guardedTransport, so a host resolving to
+// an internal address is refused even after a redirect or a DNS change.
+func remoteHTTPClient() *http.Client {
+	remoteClientOnce.Do(func() {
+		maxRedirects := config.GetRemoteFetchMaxRedirects()
+		remoteClient = &http.Client{
+			Transport: guardedTransport(config.GetRemoteFetchAllowPrivate()),
+			Timeout:   server.HttpClient.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return errTooManyRedirects
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return errUnsupportedScheme
+				}
+				return nil
+			},
+		}
+	})
+	return remoteClient
+}
+
+// guardedTransport returns a copy of the server.HttpClient transport that
+// refuses to connect to internal addresses, unless allowPrivate is set.
+// Proxies are not used, since they would connect on its behalf.
+func guardedTransport(allowPrivate bool) *http.Transport {
+	transport, ok := server.HttpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.Proxy = nil
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = checkDialAddress
+	}
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// FetchRemoteImage downloads a JPEG or PNG image from rawURL. The response
+// must declare an image content type, and bodies larger than the configured
+// limit are refused without being read in full.
+func FetchRemoteImage(ctx context.Context, rawURL string) (RemoteImage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return RemoteImage{}, NewError(ErrCodeInvalidRequest, "url must be an absolute http or https URL", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return RemoteImage{}, NewError(ErrCodeInvalidRequest, "url must be an absolute http or https URL", err)
+	}
+	req.Header.Set("Accept", "image/jpeg, image/png")
+
+	resp, err := remoteHTTPClient().Do(req)
+	switch {
+	case err == nil:
+	case errors.Is(err, errBlockedAddress):
+		return RemoteImage{}, NewError(ErrCodeInvalidRequest, "url points to a private or reserved address", err)
+	case errors.Is(err, errTooManyRedirects):
+		return RemoteImage{}, NewError(ErrCodeInvalidRequest, "url redirects too many times", err)
+	case errors.Is(err, errUnsupportedScheme):
+		return RemoteImage{}, NewError(ErrCodeInvalidRequest, "url redirects to an unsupported scheme", err)
+	case ctx.Err() != nil:
+		return RemoteImage{}, ctx.Err()
+	default:
+		return RemoteImage{}, NewError(ErrCodeFetchFailed, "Failed to download image", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return RemoteImage{}, NewError(ErrCodeFetchFailed, fmt.Sprintf("Remote server responded with %s", resp.Status), nil)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "image/jpeg" && mediaType != "image/png" {
+		return RemoteImage{}, NewError(ErrCodeUnsupportedFormat, "Remote file is not a supported image (JPEG or PNG)", nil)
+	}
+
+	maxBytes := config.GetRemoteFetchMaxBytes()
+	tooLarge := NewError(ErrCodeTooLarge, fmt.Sprintf("Remote file exceeds %d bytes", maxBytes), nil)
+	if resp.ContentLength > maxBytes {
+		return RemoteImage{}, tooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return RemoteImage{}, NewError(ErrCodeFetchFailed, "Failed to download image", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return RemoteImage{}, tooLarge
+	}
+	// The declared type is not trusted on its own
+	if contentType := http.DetectContentType(data); contentType != "image/jpeg" && contentType != "image/png" {
+		return RemoteImage{}, NewError(ErrCodeUnsupportedFormat, "Remote file is not a supported image (JPEG or PNG)", nil)
+	}
+
+	return RemoteImage{Filename: remoteFilename(u), Data: data}, nil
+}
+
+// remoteFilename names a downloaded image after the last segment of its URL
+func remoteFilename(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == ".." {
+		return "remote"
+	}
+	return name
+}
+
+// checkDialAddress refuses connections to loopback, private, link-local and
+// other reserved addresses. It runs after name resolution, on the address
+// actually dialed.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || blockedIP(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, host)
+	}
+	return nil
+}
+
+// blockedIP reports whether ip must not be fetched from
+func blockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// mustParseCIDRs parses fixed network ranges
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,100 @@
+package service
+
+import "time"
+
+// VariantResult is the outcome of producing one variant of an image
+type VariantResult struct {
+	Name       string          `json:"name"`
+	Path       string          `json:"path,omitempty"` // Local storage path or S3 URL
+	Bytes      int64           `json:"bytes,omitempty"`
+	Width      int             `json:"width,omitempty"`
+	Height     int             `json:"height,omitempty"`
+	Format     string          `json:"format"`
+	DurationMs int64           `json:"duration_ms"`
+	Checksum   *UploadChecksum `json:"checksum,omitempty"` // Only set for S3 uploads
+	Error      string          `json:"error,omitempty"`
+	ErrorCode  string          `json:"error_code,omitempty"`
+}
+
+// newVariantResult starts the result of a variant; call finish once it is done
+func newVariantResult(spec variantSpec) VariantResult {
+	return VariantResult{
+		Name:   spec.Name,
+		Width:  spec.Width,
+		Height: spec.Height,
+		Format: spec.Format,
+	}
+}
+
+// finish records how long the variant took and why it failed, if it did
+func (v VariantResult) finish(started time.Time, err error) VariantResult {
+	v.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		v.Path = ""
+		v.Checksum = nil
+		v.Error = err.Error()
+		v.ErrorCode = ErrorCode(err)
+	}
+	return v
+}
+
+// ImageResult holds the stored variants of a processed image
+type ImageResult struct {
+	ID       string          `json:"id,omitempty"` // Set once the stored variants are recorded
+	Variants []VariantResult `json:"variants"`
+	Source   *VariantResult  `json:"source,omitempty"` // The uploaded file as it was received, once stored
+	StoredAt time.Time       `json:"stored_at"`        // When the image was first stored; it fills the date placeholders of its keys
+	CacheHit bool            `json:"-"`                // Whether the result came from the cache
+}
+
+// Paths maps every successful variant to its path or URL
+func (r ImageResult) Paths() map[string]string {
+	paths := make(map[string]string)
+	for _, v := range r.Variants {
+		if v.Error == "" {
+			paths[v.Name] = v.Path
+		}
+	}
+	return paths
+}
+
+// Failed returns the variants that could not be produced
+func (r ImageResult) Failed() []VariantResult {
+	var failed []VariantResult
+	for _, v := range r.Variants {
+		if v.Error != "" {
+			failed = append(failed, v)
+		}
+	}
+	return failed
+}
+
+// FailureCode returns the code describing why variants failed, preferring
+// storage problems since they usually affect every variant alike
+func (r ImageResult) FailureCode() string {
+	code := ""
+	for _, v := range r.Failed() {
+		if v.ErrorCode == ErrCodeStorageUnavailable {
+			return v.ErrorCode
+		}
+		code = v.ErrorCode
+	}
+	return code
+}
+
+// Complete reports whether every variant was produced. Only complete results are cached.
+func (r ImageResult) Complete() bool {
+	return len(r.Variants) > 0 && len(r.Failed()) == 0
+}
+
+// size estimates the memory an entry takes, for the byte limit of the cache
+func (r ImageResult) size() int64 {
+	var n int64
+	for _, v := range r.Variants {
+		n += int64(len(v.Name)+len(v.Path)+len(v.Format)+len(v.Error)) + 40
+		if v.Checksum != nil {
+			n += int64(len(v.Checksum.MD5) + len(v.Checksum.SHA256))
+		}
+	}
+	return n
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// DefaultS3Profile is used when a request does not name an upload profile
+const DefaultS3Profile = "default"
+
+// maxProfileTags leaves room for the variant tag added to every object
+const maxProfileTags = 9
+
+// S3Profile holds the object settings applied to every variant uploaded with it
+type S3Profile struct {
+	CacheControl         string            `json:"cache_control"`
+	ContentDisposition   string            `json:"content_disposition"`
+	StorageClass         string            `json:"storage_class"`
+	ServerSideEncryption string            `json:"server_side_encryption"`
+	SSEKMSKeyID          string            `json:"sse_kms_key_id"`
+	Metadata             map[string]string `json:"metadata"`
+	Tags                 map[string]string `json:"tags"`
+}
+
+// UploadOptions describes who an upload belongs to and how it is stored
+type UploadOptions struct {
+	Tenant  string `json:"tenant"`
+	Profile string `json:"profile"`
+	// OnVariant, if set, is called as each variant finishes. It may be called
+	// from several goroutines at once.
+	OnVariant func(VariantResult) `json:"-"`
+}
+
+// notify reports finished variants to OnVariant
+func (o UploadOptions) notify(variants ...VariantResult) {
+	if o.OnVariant == nil {
+		return
+	}
+	for _, variant := range variants {
+		o.OnVariant(variant)
+	}
+}
+
+// s3Profiles holds the loaded profiles, always including the default one
+var s3Profiles = struct {
+	sync.RWMutex
+	data map[string]S3Profile
+}{data: map[string]S3Profile{DefaultS3Profile: {}}}
+
+// LoadS3Profiles reads upload profiles from a JSON file keyed by profile name.
+// An empty path keeps only the built-in default profile.
+func LoadS3Profiles(path string) error {
+	profiles := map[string]S3Profile{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read S3 profiles: %v", err)
+		}
+		if err := json.Unmarshal(data, &profiles); err != nil {
+			return fmt.Errorf("failed to parse S3 profiles: %v", err)
+		}
+	}
+
+	for name, profile := range profiles {
+		if err := profile.validate(); err != nil {
+			return fmt.Errorf("S3 profile %q: %v", name, err)
+		}
+	}
+	if _, ok := profiles[DefaultS3Profile]; !ok {
+		profiles[DefaultS3Profile] = S3Profile{}
+	}
+
+	s3Profiles.Lock()
+	defer s3Profiles.Unlock()
+	s3Profiles.data = profiles
+	return nil
+}
+
+// GetS3Profile returns the named upload profile
+func GetS3Profile(name string) (S3Profile, bool) {
+	if name == "" {
+		name = DefaultS3Profile
+	}
+	s3Profiles.RLock()
+	defer s3Profiles.RUnlock()
+	profile, ok := s3Profiles.data[name]
+	return profile, ok
+}
+
+// validate rejects settings S3 would refuse at upload time
+func (p S3Profile) validate() error {
+	if p.StorageClass != "" && !contains(s3.StorageClass_Values(), p.StorageClass) {
+		return fmt.Errorf("unknown storage class %q", p.StorageClass)
+	}
+	if p.ServerSideEncryption != "" && !contains(s3.ServerSideEncryption_Values(), p.ServerSideEncryption) {
+		return fmt.Errorf("unknown server side encryption %q", p.ServerSideEncryption)
+	}
+	if p.SSEKMSKeyID != "" && !isKMSEncryption(p.ServerSideEncryption) {
+		return fmt.Errorf("sse_kms_key_id requires server_side_encryption %q or %q", s3.ServerSideEncryptionAwsKms, s3.ServerSideEncryptionAwsKmsDsse)
+	}
+	if len(p.Tags) > maxProfileTags {
+		return fmt.Errorf("at most %d tags are allowed", maxProfileTags)
+	}
+	return nil
+}
+
+// fingerprint identifies the settings of the profile. Profiles with the same
+// settings, whatever their name, share it, and editing a profile changes it.
+func (p S3Profile) fingerprint() string {
+	// Map keys are sorted when marshalled, so equal settings encode the same
+	data, _ := json.Marshal(p)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// variantObjectInfo describes the variant an uploaded object holds
+type variantObjectInfo struct {
+	Variant        string
+	Tenant         string
+	SourceHash     string
+	OriginalWidth  int
+	OriginalHeight int
+}
+
+// apply copies the profile settings and variant metadata onto a PutObject request
+func (p S3Profile) apply(input *s3.PutObjectInput, info variantObjectInfo) {
+	if p.CacheControl != "" {
+		input.CacheControl = aws.String(p.CacheControl)
+	}
+	if p.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(p.ContentDisposition)
+	}
+	if p.StorageClass != "" {
+		input.StorageClass = aws.String(p.StorageClass)
+	}
+	if p.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(p.ServerSideEncryption)
+	}
+	if p.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(p.SSEKMSKeyID)
+	}
+
+	// Variant details always win over custom metadata with the same name
+	metadata := map[string]*string{}
+	for k, v := range p.Metadata {
+		metadata[k] = aws.String(v)
+	}
+	metadata["variant"] = aws.String(info.Variant)
+	metadata["tenant"] = aws.String(info.Tenant)
+	metadata["source-sha256"] = aws.String(info.SourceHash)
+	metadata["original-width"] = aws.String(strconv.Itoa(info.OriginalWidth))
+	metadata["original-height"] = aws.String(strconv.Itoa(info.OriginalHeight))
+	input.Metadata = metadata
+
+	tags := url.Values{}
+	for k, v := range p.Tags {
+		tags.Set(k, v)
+	}
+	tags.Set("variant", info.Variant)
+	input.Tagging = aws.String(tags.Encode())
+}
+
+// applyCopy sets the profile settings a CopyObject request does not take from
+// the object it copies; metadata and tags are copied along
+func (p S3Profile) applyCopy(input *s3.CopyObjectInput) {
+	if p.StorageClass != "" {
+		input.StorageClass = aws.String(p.StorageClass)
+	}
+	if p.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(p.ServerSideEncryption)
+	}
+	if p.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(p.SSEKMSKeyID)
+	}
+}
+
+// contains reports whether values includes v
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Import for JPEG decoding
+	_ "image/png"  // Import for PNG decoding
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+)
+
+// CheckImage reads the dimensions of an upload and rejects data that is not a
+// supported image or has more pixels than the configured limit
+func CheckImage(data []byte) (image.Config, error) {
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return image.Config{}, NewError(ErrCodeUnsupportedFormat, "File is not a supported image (JPEG or PNG)", err)
+	}
+	if imgConfig.Width <= 0 || imgConfig.Height <= 0 {
+		return image.Config{}, NewError(ErrCodeUnsupportedFormat, "Image has no pixels", nil)
+	}
+
+	limit := config.GetMaxImagePixels()
+	if pixels := int64(imgConfig.Width) * int64(imgConfig.Height); pixels > limit {
+		message := fmt.Sprintf("Image has %d pixels, the limit is %d", pixels, limit)
+		return image.Config{}, NewError(ErrCodePixelLimit, message, nil)
+	}
+	return imgConfig, nil
+}
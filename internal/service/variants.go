@@ -0,0 +1,85 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// variantNames lists every variant the pipeline produces
+var variantNames = []string{"original", "250-300KB", "150-200KB", "10-50KB"}
+
+// variantSpec describes how one variant of an image is encoded
+type variantSpec struct {
+	Name    string
+	Quality int
+	Width   int
+	Height  int
+	Format  string
+}
+
+// variantSpecs returns the encoding settings of every variant of an image
+func variantSpecs(size int64, originalWidth, originalHeight int) []variantSpec {
+	// Define compression quality levels and target sizes
+	qualities := map[string]int{
+		"original":  determineOriginalSizeReduction(size), // Original size with potential reduction
+		"250-300KB": 80,                                   // Adjust quality for 250-300KB
+		"150-200KB": 60,                                   // Adjust quality for 150-200KB
+		"10-50KB":   20,                                   // Adjust quality for 10-50KB
+	}
+
+	aspectRatio := float64(originalWidth) / float64(originalHeight)
+
+	specs := make([]variantSpec, 0, len(variantNames))
+	for _, name := range variantNames {
+		// Calculate dimensions based on aspect ratio
+		var newWidth, newHeight int
+		if name == "original" {
+			newWidth, newHeight = originalWidth, originalHeight // Keep original dimensions
+		} else {
+			// Scale dimensions to maintain aspect ratio (start with a reasonable base width)
+			baseWidth := 1200 // Starting point, adjust if needed
+			newWidth = baseWidth
+			newHeight = int(float64(newWidth) / aspectRatio)
+		}
+
+		specs = append(specs, variantSpec{
+			Name:    name,
+			Quality: qualities[name],
+			Width:   newWidth,
+			Height:  newHeight,
+			Format:  "jpeg",
+		})
+	}
+	return specs
+}
+
+// String describes a spec in a stable form used for cache keys
+func (v variantSpec) String() string {
+	return fmt.Sprintf("%s:q%d:%dx%d:%s", v.Name, v.Quality, v.Width, v.Height, v.Format)
+}
+
+// contentCacheKey identifies the result of encoding an image with a set of specs.
+// Identical bytes share a key whatever their file name, and any change to the
+// specs produces a different one.
+func contentCacheKey(imageHash string, specs []variantSpec) string {
+	parts := make([]string, len(specs))
+	for i, spec := range specs {
+		parts[i] = spec.String()
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, ";")))
+	return imageHash + "-" + hex.EncodeToString(sum[:8])
+}
+
+// Determines the original image compression based on file size
+func determineOriginalSizeReduction(size int64) int {
+	switch {
+	case size > 5*1024*1024: // If size > 5MB
+		return 50
+	case size > 2*1024*1024: // If size is between 2MB and 5MB
+		return 70
+	default: // If size is < 2MB
+		return 100 // No compression
+	}
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhinandpn/CompressImage/internal/config"
+	"github.com/abhinandpn/CompressImage/server"
+)
+
+// maxWebhookBackoff caps the wait between two delivery attempts
+const maxWebhookBackoff = 5 * time.Minute
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookPayload is the JSON body sent to a callback URL when a job finishes
+type WebhookPayload struct {
+	Event      string          `json:"event"` // "job." followed by the final job status
+	JobID      string          `json:"job_id"`
+	Status     string          `json:"status"`
+	Filename   string          `json:"filename"`
+	Variants   []VariantResult `json:"variants"`
+	Error      *WebhookError   `json:"error,omitempty"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// WebhookError describes why a job failed
+type WebhookError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// WebhookAttempt records one try to deliver a payload
+type WebhookAttempt struct {
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// WebhookDelivery is the log entry of a payload and every attempt to send it
+type WebhookDelivery struct {
+	ID          string           `json:"id"`
+	JobID       string           `json:"job_id"`
+	URL         string           `json:"url"`
+	Event       string           `json:"event"`
+	Status      string           `json:"status"`
+	Attempts    []WebhookAttempt `json:"attempts"`
+	NextAttempt *time.Time       `json:"next_attempt,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+}
+
+// WebhookDispatcher sends signed payloads to callback URLs, retrying failed
+// deliveries with exponential backoff, and keeps a log of every delivery
+type WebhookDispatcher struct {
+	mu           sync.Mutex
+	secret       []byte
+	maxAttempts  int
+	backoff      time.Duration
+	retention    time.Duration
+	allowPrivate bool
+	client       *http.Client
+	ctx          context.Context
+	deliveries   map[string]*WebhookDelivery
+}
+
+// NewWebhookDispatcher creates a dispatcher signing with secret. Deliveries
+// still retrying when ctx is done are abandoned, and finished deliveries are
+// forgotten after retention. Unless allowPrivate is set, callbacks are never
+// sent to internal addresses.
+func NewWebhookDispatcher(ctx context.Context, secret string, maxAttempts int, backoff, retention time.Duration, allowPrivate bool) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		secret:       []byte(secret),
+		maxAttempts:  maxAttempts,
+		backoff:      backoff,
+		retention:    retention,
+		allowPrivate: allowPrivate,
+		client: &http.Client{
+			Transport: guardedTransport(allowPrivate),
+			Timeout:   server.HttpClient.Timeout,
+			// A redirect could point anywhere, so it counts as a failed delivery
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		ctx:        ctx,
+		deliveries: make(map[string]*WebhookDelivery),
+	}
+	go d.maintain()
+	return d
+}
+
+// Webhooks delivers job callbacks; it is set up by ConfigureWebhooks
+var Webhooks *WebhookDispatcher
+
+// ConfigureWebhooks sets up webhook delivery from the environment. It is meant
+// to be called once at startup.
+func ConfigureWebhooks(ctx context.Context) {
+	Webhooks = NewWebhookDispatcher(ctx, config.GetWebhookSecret(), config.GetWebhookMaxAttempts(), config.GetWebhookBackoff(), config.GetJobRetention(), config.GetWebhookAllowPrivate())
+}
+
+// ValidateCallbackURL checks that rawURL can receive webhooks
+func (d *WebhookDispatcher) ValidateCallbackURL(rawURL string) error {
+	if len(d.secret) == 0 {
+		return NewError(ErrCodeInvalidRequest, "Webhooks are not enabled on this server", nil)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return NewError(ErrCodeInvalidRequest, "callback_url must be an absolute http or https URL", err)
+	}
+
+	// Names are checked again on every connection, after they are resolved
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	ip := net.ParseIP(host)
+	if !d.allowPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && blockedIP(ip))) {
+		return NewError(ErrCodeInvalidRequest, "callback_url points to a private or reserved address", nil)
+	}
+	return nil
+}
+
+// Notify starts delivering the outcome of a finished job to callbackURL.
+// done, if not nil, is called with DeliveryDelivered or DeliveryFailed once
+// the delivery has stopped; it is not called when ctx ends it early.
+func (d *WebhookDispatcher) Notify(callbackURL string, job Job, done func(status string)) {
+	payload := newWebhookPayload(job)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode webhook for job %s: %v", job.ID, err)
+		return
+	}
+	id, err := newJobID()
+	if err != nil {
+		log.Printf("Failed to create webhook delivery for job %s: %v", job.ID, err)
+		return
+	}
+
+	delivery := &WebhookDelivery{
+		ID:        id,
+		JobID:     job.ID,
+		URL:       callbackURL,
+		Event:     payload.Event,
+		Status:    DeliveryPending,
+		CreatedAt: time.Now(),
+	}
+	d.mu.Lock()
+	d.pruneLocked()
+	d.deliveries[id] = delivery
+	d.mu.Unlock()
+
+	go func() {
+		if status := d.deliver(delivery, body); done != nil && status != DeliveryPending {
+			done(status)
+		}
+	}()
+}
+
+// Deliveries returns the logged deliveries, newest first. Empty filters match
+// every delivery.
+func (d *WebhookDispatcher) Deliveries(jobID, status string) []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pruneLocked()
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range d.deliveries {
+		if (jobID == "" || delivery.JobID == jobID) && (status == "" || delivery.Status == status) {
+			snapshot := *delivery
+			snapshot.Attempts = append([]WebhookAttempt(nil), delivery.Attempts...)
+			deliveries = append(deliveries, snapshot)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	return deliveries
+}
+
+// deliver sends a payload until it is accepted, fails permanently or runs out
+// of attempts, and returns the final status. It stays pending when ctx is done
+// before then.
+func (d *WebhookDispatcher) deliver(delivery *WebhookDelivery, body []byte) string {
+	for attempt := 1; ; attempt++ {
+		record, retry := d.send(delivery, body, attempt)
+
+		d.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, record)
+		delivery.NextAttempt = nil
+		switch {
+		case record.Error == "":
+			delivery.Status = DeliveryDelivered
+		case !retry || attempt >= d.maxAttempts:
+			delivery.Status = DeliveryFailed
+		}
+		if status := delivery.Status; status != DeliveryPending {
+			now := time.Now()
+			delivery.FinishedAt = &now
+			d.mu.Unlock()
+			if status == DeliveryFailed {
+				log.Printf("Webhook %s for job %s failed after %d attempts: %s", delivery.ID, delivery.JobID, attempt, record.Error)
+			}
+			return status
+		}
+		wait := d.backoff << (attempt - 1)
+		if wait <= 0 || wait > maxWebhookBackoff {
+			wait = maxWebhookBackoff
+		}
+		next := time.Now().Add(wait)
+		delivery.NextAttempt = &next
+		d.mu.Unlock()
+
+		select {
+		case <-d.ctx.Done():
+			return DeliveryPending
+		case <-time.After(wait):
+		}
+	}
+}
+
+// send makes a single delivery attempt and reports whether a failure is worth retrying
+func (d *WebhookDispatcher) send(delivery *WebhookDelivery, body []byte, attempt int) (WebhookAttempt, bool) {
+	started := time.Now()
+	record := WebhookAttempt{Attempt: attempt, Time: started}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		record.Error = err.Error()
+		return record, false
+	}
+	timestamp := strconv.FormatInt(started.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+d.sign(timestamp, body))
+
+	resp, err := d.client.Do(req)
+	record.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		record.Error = err.Error()
+		return record, !errors.Is(err, context.Canceled) && !errors.Is(err, errBlockedAddress)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	record.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return record, true
+	}
+	record.Error = fmt.Sprintf("callback responded with %s", resp.Status)
+	// Other client errors mean the receiver rejects the payload, retrying will not help
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return record, retry
+}
+
+// sign computes the HMAC-SHA256 of the timestamp and body. Receivers verify
+// it by signing "<X-Webhook-Timestamp>.<body>" with the shared secret.
+func (d *WebhookDispatcher) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// maintain prunes finished deliveries every pruneInterval until ctx is done
+func (d *WebhookDispatcher) maintain() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			d.pruneLocked()
+			d.mu.Unlock()
+		}
+	}
+}
+
+// pruneLocked forgets deliveries that finished more than the retention ago;
+// the caller must hold the lock
+func (d *WebhookDispatcher) pruneLocked() {
+	cutoff := time.Now().Add(-d.retention)
+	for id, delivery := range d.deliveries {
+		if delivery.FinishedAt != nil && delivery.FinishedAt.Before(cutoff) {
+			delete(d.deliveries, id)
+		}
+	}
+}
+
+// newWebhookPayload describes a finished job
+func newWebhookPayload(job Job) WebhookPayload {
+	payload := WebhookPayload{
+		Event:      "job." + job.Status,
+		JobID:      job.ID,
+		Status:     job.Status,
+		Filename:   job.Filename,
+		Variants:   []VariantResult{},
+		FinishedAt: job.FinishedAt,
+	}
+	if job.Result != nil {
+		payload.Variants = job.Result.Variants
+	}
+	if job.Err != nil {
+		payload.Error = &WebhookError{Code: ErrorCode(job.Err), Message: "Failed to process image"}
+		var serviceErr *Error
+		if errors.As(job.Err, &serviceErr) {
+			payload.Error.Message = serviceErr.Message
+		}
+	}
+	return payload
+}
@@ -0,0 +1,188 @@
+package redis_client
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestServer starts a miniredis server that is closed with the test
+func newTestServer(t *testing.T, password string) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	if password != "" {
+		server.RequireAuth(password)
+	}
+	return server
+}
+
+// newTestClient creates a client that is closed with the test
+func newTestClient(t *testing.T, opts Options) *Client {
+	t.Helper()
+	client := NewClient(opts)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestGetSetDel(t *testing.T) {
+	server := newTestServer(t, "")
+	client := newTestClient(t, Options{Addr: server.Addr()})
+
+	if _, err := client.Get("missing"); !errors.Is(err, ErrNil) {
+		t.Fatalf("Get of a missing key: got %v, want ErrNil", err)
+	}
+	if err := client.Set("key", "value\r\nwith a line break", 0); err != nil {
+		t.Fatal(err)
+	}
+	value, err := client.Get("key")
+	if err != nil || value != "value\r\nwith a line break" {
+		t.Fatalf("Get: got %q, %v", value, err)
+	}
+	if stored, _ := server.Get("key"); stored != value {
+		t.Errorf("server holds %q, want %q", stored, value)
+	}
+	if ttl := server.TTL("key"); ttl != 0 {
+		t.Errorf("key set without a ttl expires in %v", ttl)
+	}
+
+	n, err := client.Del("key", "missing")
+	if err != nil || n != 1 {
+		t.Fatalf("Del: got %d, %v, want 1", n, err)
+	}
+	if _, err := client.Get("key"); !errors.Is(err, ErrNil) {
+		t.Fatalf("Get after Del: got %v, want ErrNil", err)
+	}
+}
+
+func TestSetPX(t *testing.T) {
+	server := newTestServer(t, "")
+	client := newTestClient(t, Options{Addr: server.Addr()})
+
+	tests := []struct {
+		key  string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{"seconds", 90 * time.Second, 90 * time.Second},
+		{"milliseconds", 1500 * time.Microsecond, time.Millisecond},
+		{"under a millisecond", time.Microsecond, time.Millisecond}, // PX 0 is refused
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if err := client.Set(tt.key, "value", tt.ttl); err != nil {
+				t.Fatal(err)
+			}
+			if ttl := server.TTL(tt.key); ttl != tt.want {
+				t.Errorf("key expires in %v, want %v", ttl, tt.want)
+			}
+		})
+	}
+
+	server.FastForward(time.Minute)
+	if _, err := client.Get("milliseconds"); !errors.Is(err, ErrNil) {
+		t.Errorf("Get of an expired key: got %v, want ErrNil", err)
+	}
+	if _, err := client.Get("seconds"); err != nil {
+		t.Errorf("Get of a key that has not expired: %v", err)
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	server := newTestServer(t, "")
+	client := newTestClient(t, Options{Addr: server.Addr(), PoolSize: 1})
+
+	_, err := client.Do("NOSUCHCOMMAND")
+	var replyErr Error
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(string(replyErr), "ERR unknown command") {
+		t.Fatalf("got %v, want an error reply", err)
+	}
+
+	// An error reply leaves the connection in the pool and usable
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping after an error reply: %v", err)
+	}
+	if len(client.pool) != 1 {
+		t.Errorf("pool holds %d connections, want 1", len(client.pool))
+	}
+}
+
+func TestAuthAndSelect(t *testing.T) {
+	server := newTestServer(t, "secret")
+
+	client := newTestClient(t, Options{Addr: server.Addr(), Password: "secret", DB: 3})
+	if err := client.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := server.DB(3).Get("key"); err != nil || value != "value" {
+		t.Errorf("database 3 holds %q, %v", value, err)
+	}
+	if server.Exists("key") {
+		t.Error("key was also stored in database 0")
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		wantCode string
+		pooled   int // Connections failing AUTH or SELECT are not kept
+	}{
+		{"wrong password", Options{Password: "wrong"}, "WRONGPASS", 0},
+		{"no password", Options{}, "NOAUTH", 1},
+		{"invalid database", Options{Password: "secret", DB: -1}, "ERR", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Addr = server.Addr()
+			client := newTestClient(t, tt.opts)
+			err := client.Ping()
+			var replyErr Error
+			if !errors.As(err, &replyErr) || !strings.HasPrefix(string(replyErr), tt.wantCode) {
+				t.Fatalf("got %v, want a %s error reply", err, tt.wantCode)
+			}
+			if len(client.pool) != tt.pooled {
+				t.Errorf("pool holds %d connections, want %d", len(client.pool), tt.pooled)
+			}
+		})
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    interface{}
+		wantErr error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"integer", ":42\r\n", int64(42), nil},
+		{"bulk string", "$5\r\nhello\r\n", "hello", nil},
+		{"empty bulk string", "$0\r\n\r\n", "", nil},
+		{"nil bulk string", "$-1\r\n", nil, nil},
+		{"nil array", "*-1\r\n", nil, nil},
+		{"error", "-ERR failed\r\n", nil, Error("ERR failed")},
+		{"array", "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", []interface{}{"a", int64(1), nil}, nil},
+		{"error inside an array", "*2\r\n+OK\r\n-ERR failed\r\n", []interface{}{"OK", Error("ERR failed")}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	for _, input := range []string{"", "\r\n", "?what\r\n", "+OK\n", "$5\r\nhi\r\n"} {
+		if _, err := readReply(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("readReply(%q) succeeded, want an error", input)
+		}
+	}
+}
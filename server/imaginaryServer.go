@@ -8,13 +8,15 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 
 	"github.com/nfnt/resize"
 )
 
 // ProcessImageWithImaginary calls Imaginary API to resize/compress images
 // ProcessImageWithImaginary compresses and resizes an image while keeping aspect ratio
-func ProcessImageWithImaginary(imageData []byte, quality int, outputName string, width int, height int) (string, error) {
+// outputKey is the path of the encoded file relative to the storage folder
+func ProcessImageWithImaginary(imageData []byte, quality int, outputKey string, width int, height int) (string, error) {
 	img, _, err := image.Decode(bytes.NewReader(imageData))
 	if err != nil {
 		return "", err
@@ -23,20 +25,33 @@ func ProcessImageWithImaginary(imageData []byte, quality int, outputName string,
 	// Resize the image while keeping the aspect ratio
 	resizedImg := resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
 
-	// Create the output file
-	outputPath := fmt.Sprintf("storage/%s.jpg", outputName)
-	outFile, err := os.Create(outputPath)
+	// Encode into a temporary file and move it into place, so concurrent
+	// requests for the same key never see a partly written file
+	outputPath := filepath.Join("storage", outputKey)
+	if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	outFile, err := os.CreateTemp(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".*")
 	if err != nil {
 		return "", err
 	}
-	defer outFile.Close()
+	defer os.Remove(outFile.Name()) // Only left behind when something failed
 
 	// Encode the resized image
 	options := &jpeg.Options{Quality: quality}
 	err = jpeg.Encode(outFile, resizedImg, options)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return "", err
 	}
+	if err := os.Chmod(outFile.Name(), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(outFile.Name(), outputPath); err != nil {
+		return "", err
+	}
 
 	return outputPath, nil
 }